@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"os"
-	"strings"
+	"os/signal"
+	"path/filepath"
+	"time"
 
 	"github.com/sporollan/site/internal/builder"
+	"github.com/sporollan/site/internal/check"
 	"github.com/sporollan/site/internal/renderer"
+	"github.com/sporollan/site/internal/server"
 	"github.com/sporollan/site/internal/site"
 )
 
@@ -17,53 +24,160 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func run() error {
-	// Load configuration from environment variables
-	inputDir := getEnv("SITE_INPUT_DIR", "content")
-	outputDir := getEnv("SITE_OUTPUT_DIR", "public")
-	staticDir := getEnv("SITE_STATIC_DIR", "static")
-	templateDir := getEnv("SITE_TEMPLATE_DIR", "templates")
-	siteName := getEnv("SITE_NAME", "Santiago Porollan")
-	baseURL := getEnv("SITE_BASE_URL", "http://localhost:8080")
-	
-	// Ensure base URL has proper protocol and no trailing slash
-	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
-		baseURL = "https://" + baseURL
-	}
-	baseURL = strings.TrimSuffix(baseURL, "/")
-	
-	// Detect if we're in production (GitHub Actions sets GITHUB_ACTIONS=true)
-	isProduction := os.Getenv("GITHUB_ACTIONS") == "true"
-	
-	if isProduction {
-		log.Printf("Production build for: %s", baseURL)
-	} else {
-		log.Printf("Development build for: %s", baseURL)
-	}
-	
-	// Initialize site
-	s := site.NewWithConfig(
-		inputDir,
-		outputDir,
-		staticDir,
-		templateDir,
-		siteName,
-		baseURL,
-	)
-	
-	// Create renderer
+// configPath is SITE_CONFIG, or site.toml when there is no site.yaml
+func configPath() string {
+	if path, exists := os.LookupEnv("SITE_CONFIG"); exists {
+		return path
+	}
+	if _, err := os.Stat("site.yaml"); os.IsNotExist(err) {
+		if _, err := os.Stat("site.toml"); err == nil {
+			return "site.toml"
+		}
+	}
+	return "site.yaml"
+}
+
+func loadSite() (*site.Site, error) {
+	// Pick the config environment. GitHub Actions builds are production
+	// unless SITE_ENV says otherwise.
+	defaultEnv := "development"
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		defaultEnv = "production"
+	}
+	env := getEnv("SITE_ENV", defaultEnv)
+
+	// Load the config file, then let SITE_* environment variables override it
+	cfg, err := site.LoadConfig(configPath(), env)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ApplyEnv()
+
+	log.Printf("Building %s environment for: %s", cfg.Environment, cfg.BaseURL)
+
+	return site.New(cfg), nil
+}
+
+func build(s *site.Site) error {
+	// Create renderer. Templates are parsed on every build so the dev
+	// server picks up template changes.
 	r, err := renderer.New(s.TemplateDir)
 	if err != nil {
 		return err
 	}
-	
+
 	// Create builder and build site
 	b := builder.New(s, r, 4)
 	return b.Build()
 }
 
+// checkLinks reports the references in the output directory that don't
+// resolve, and fails if there are any. With -external it also requests
+// every link to another site.
+func checkLinks(s *site.Site, args []string) error {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	external := flags.Bool("external", false, "also check links to other sites")
+	concurrency := flags.Int("concurrency", 8, "external requests in flight at once")
+	ttl := flags.Duration("ttl", 24*time.Hour, "how long working external links are cached")
+	flags.Parse(args)
+
+	if _, err := os.Stat(s.OutputDir); err != nil {
+		return fmt.Errorf("nothing to check in %s, run site build first: %w", s.OutputDir, err)
+	}
+
+	checker := check.New(s.OutputDir, s.BaseURL)
+	problems, err := checker.Internal()
+	if err != nil {
+		return err
+	}
+
+	if *external {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		opts := check.ExternalOptions{Concurrency: *concurrency, TTL: *ttl}
+		if s.CacheDir != "" {
+			opts.CacheFile = filepath.Join(s.CacheDir, "links.json")
+		}
+		remote, err := checker.External(ctx, opts)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, remote...)
+	}
+
+	if len(problems) > 0 {
+		check.WriteReport(os.Stdout, s.OutputDir, problems)
+		return fmt.Errorf("found %d broken references", len(problems))
+	}
+	log.Printf("No broken references in %s", s.OutputDir)
+	return nil
+}
+
+func serve(s *site.Site, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	flags.Parse(args)
+
+	if err := build(s); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Reload the config on every rebuild so its edits apply too
+	rebuild := func() error {
+		s, err := loadSite()
+		if err != nil {
+			return err
+		}
+		return build(s)
+	}
+
+	srv := server.New(s.OutputDir, rebuild, []string{
+		configPath(),
+		s.InputDir,
+		s.TemplateDir,
+		s.StaticDir,
+	})
+	return srv.ListenAndServe(ctx, *addr)
+}
+
+func run(args []string) error {
+	s, err := loadSite()
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return build(s)
+	}
+
+	switch args[0] {
+	case "build":
+		flags := flag.NewFlagSet("build", flag.ExitOnError)
+		checkOutput := flags.Bool("check", false, "check the output for broken references")
+		flags.Parse(args[1:])
+
+		if err := build(s); err != nil {
+			return err
+		}
+		if *checkOutput {
+			return checkLinks(s, nil)
+		}
+		return nil
+	case "check":
+		return checkLinks(s, args[1:])
+	case "serve":
+		return serve(s, args[1:])
+	default:
+		return fmt.Errorf("unknown command %q (want build, check or serve)", args[0])
+	}
+}
+
 func main() {
-	if err := run(); err != nil {
+	if err := run(os.Args[1:]); err != nil {
 		log.Fatal(err)
 	}
-}
\ No newline at end of file
+}
@@ -1,7 +1,7 @@
 #!/bin/bash
 
-# Set development environment variables
-export SITE_BASE_URL="http://localhost:8080"
-export SITE_NAME="Santiago Porollan (Dev)"
+# Build, serve public/ and rebuild + reload the browser on changes.
+# Settings come from the development environment in site.yaml.
+export SITE_ENV="development"
 
-go run cmd/site/main.go
\ No newline at end of file
+go run cmd/site/main.go serve -addr :8080
@@ -3,6 +3,17 @@ module github.com/sporollan/site
 go 1.25.5
 
 require (
-	github.com/yuin/goldmark v1.7.13 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	github.com/BurntSushi/toml v1.6.0
+	github.com/alecthomas/chroma/v2 v2.27.0
+	github.com/tdewolff/minify/v2 v2.24.17
+	github.com/yuin/goldmark v1.7.13
+	golang.org/x/image v0.36.0
+	golang.org/x/net v0.50.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/dlclark/regexp2/v2 v2.2.1 // indirect
+	github.com/tdewolff/parse/v2 v2.8.16 // indirect
+	golang.org/x/text v0.34.0 // indirect
 )
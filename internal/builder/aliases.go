@@ -0,0 +1,66 @@
+package builder
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// writeAliases writes a redirect to every page at each of its aliases
+func (b *Builder) writeAliases() error {
+	owned := make(map[string]string, len(b.site.Pages))
+	for _, page := range b.site.Pages {
+		owned[page.Permalink] = page.Path
+	}
+
+	for _, page := range b.site.Pages {
+		for _, alias := range page.Aliases {
+			alias = "/" + strings.Trim(alias, "/") + "/"
+			if alias == "//" {
+				alias = "/"
+			}
+
+			if other, ok := owned[alias]; ok {
+				return fmt.Errorf("%s: alias %s is the permalink of %s", page.Path, alias, other)
+			}
+			owned[alias] = page.Path
+
+			if err := b.writeAlias(alias, page.Permalink); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeAlias writes a page at from that redirects to the permalink to
+func (b *Builder) writeAlias(from, to string) error {
+	target := html.EscapeString(b.site.BaseURL + to)
+	data := fmt.Sprintf(`<!DOCTYPE html>
+<html>
+<head>
+<title>%s</title>
+<link rel="canonical" href="%s">
+<meta name="robots" content="noindex">
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0; url=%s">
+</head>
+</html>
+`, target, target, target)
+
+	path := filepath.Join(b.site.OutputDir, filepath.FromSlash(from), "index.html")
+	written, err := b.writeOutput(path, hashStrings(data), func() ([]byte, error) {
+		return []byte(data), nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if written {
+		log.Printf("Generated alias: %s -> %s", from, to)
+	}
+	return nil
+}
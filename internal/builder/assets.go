@@ -0,0 +1,143 @@
+package builder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sporollan/site/internal/site"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/js"
+)
+
+// assetManifestName lists the processed assets in the output directory
+const assetManifestName = "assets.json"
+
+// assetEntry is an asset in assets.json
+type assetEntry struct {
+	URL       string `json:"url"`
+	Integrity string `json:"integrity"`
+}
+
+// assetTypes are the media types of the files the pipeline processes
+var assetTypes = map[string]string{
+	".css": "text/css",
+	".js":  "application/javascript",
+}
+
+// buildAssets minifies, bundles and fingerprints the CSS and JS files
+// copied from static/ or generated so far, then lists them in
+// assets.json and hands them to the renderer. The originals stay in
+// place for anything linking to them directly.
+func (b *Builder) buildAssets() error {
+	cfg := b.site.Config.Assets
+
+	sources := make(map[string][]string)
+	b.mu.Lock()
+	for rel := range b.next.Outputs {
+		if _, ok := assetTypes[path.Ext(rel)]; ok {
+			sources[rel] = []string{rel}
+		}
+	}
+	b.mu.Unlock()
+	for name, inputs := range cfg.Bundles {
+		if _, ok := assetTypes[path.Ext(name)]; !ok {
+			return fmt.Errorf("assets.bundles: %s is not a CSS or JS file", name)
+		}
+		for _, input := range inputs {
+			if path.Ext(input) != path.Ext(name) {
+				return fmt.Errorf("assets.bundles: %s can't go into %s", input, name)
+			}
+		}
+		sources[name] = inputs
+	}
+
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m := minify.New()
+	m.AddFunc(assetTypes[".css"], css.Minify)
+	m.AddFunc(assetTypes[".js"], js.Minify)
+
+	assets := make(map[string]*site.Asset)
+	for _, name := range names {
+		var data bytes.Buffer
+		for i, input := range sources[name] {
+			content, err := os.ReadFile(filepath.Join(b.site.OutputDir, filepath.FromSlash(input)))
+			if err != nil {
+				return fmt.Errorf("failed to read asset %s: %w", input, err)
+			}
+			if i > 0 {
+				data.WriteByte('\n')
+			}
+			data.Write(content)
+		}
+
+		out := data.Bytes()
+		if cfg.Minify {
+			minified, err := m.Bytes(assetTypes[path.Ext(name)], out)
+			if err != nil {
+				return fmt.Errorf("failed to minify %s: %w", name, err)
+			}
+			out = minified
+		}
+
+		outName := name
+		if cfg.Fingerprint {
+			outName = fingerprint(name, out)
+		}
+		if err := b.writeGenerated(outName, out); err != nil {
+			return err
+		}
+
+		assets[name] = &site.Asset{Name: name, URL: "/" + outName, Integrity: integrity(out)}
+	}
+
+	entries := make(map[string]assetEntry, len(assets))
+	for name, asset := range assets {
+		entries[name] = assetEntry{URL: asset.URL, Integrity: asset.Integrity}
+	}
+	manifest, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode asset manifest: %w", err)
+	}
+	if err := b.writeGenerated(assetManifestName, manifest); err != nil {
+		return err
+	}
+
+	b.site.Assets = assets
+	if b.renderer != nil {
+		b.renderer.SetAssets(assets)
+	}
+
+	// Everything rendered from here on links to these files
+	b.key = hashStrings(b.key, hashBytes(manifest))
+	return nil
+}
+
+// fingerprint inserts a hash of data before the extension of name, as in
+// css/style.3fa2c1e9.css
+func fingerprint(name string, data []byte) string {
+	sum := sha256.Sum256(data)
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hex.EncodeToString(sum[:4]) + ext
+}
+
+// integrity returns the Subresource Integrity value of data
+func integrity(data []byte) string {
+	sum := sha512.Sum384(data)
+	return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"log"
 	"os"
+	pathpkg "path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sporollan/site/internal/parser"
 	"github.com/sporollan/site/internal/renderer"
@@ -16,39 +19,135 @@ import (
 type Builder struct {
 	site     *site.Site
 	renderer *renderer.Renderer
+	parser   *parser.Parser
 	workers  int
+
+	// prev is the manifest of the last build, next the one being built
+	prev *manifest
+	next *manifest
+	key  string
+
+	// lists are the generated list pages, for the sitemap
+	lists []*site.Page
+
+	// indexes holds the parsed _index.md of each section directory
+	indexes    map[string]*site.Page
+	sectionKey string
+
+	// refsKey identifies the permalinks and headings links may point at
+	refsKey string
+
+	// pagesKey identifies every page and section index, which the output
+	// of shortcodes may depend on
+	pagesKey string
+
+	// images holds the images processed in this build, by file, and
+	// imageDeps the images each content file shows
+	images    map[string]*imageJob
+	imageDeps map[string]map[string]string
+
+	// mu guards site.Pages, site.Collections and next while workers are running
+	mu sync.Mutex
 }
 
 func New(s *site.Site, r *renderer.Renderer, workers int) *Builder {
-	return &Builder{
+	b := &Builder{
 		site:     s,
 		renderer: r,
+		parser:   parser.New(s.Config.Markup),
 		workers:  workers,
+		prev:     newManifest(),
+		next:     newManifest(),
+		indexes:  make(map[string]*site.Page),
+	}
+
+	// Templates in templates/_hooks/ replace the HTML of markdown elements
+	if r != nil {
+		b.parser.SetHooks(renderHooks{b})
 	}
+	return b
 }
 
 func (b *Builder) Build() error {
-	// Clean output directory
-	if err := b.cleanOutputDir(); err != nil {
-		return fmt.Errorf("failed to clean output: %w", err)
+	key, err := b.buildKey()
+	if err != nil {
+		return err
 	}
 
+	// Load the previous build's manifest to skip unchanged inputs
+	b.prev = b.loadManifest()
+	b.next = newManifest()
+	b.key = key
+
 	// Reset site data
 	b.site.Pages = []*site.Page{}
 	b.site.Collections = make(map[string][]*site.Page)
+	b.site.Feeds = b.feedLinks()
+	b.lists = nil
+	b.indexes = make(map[string]*site.Page)
+	b.images = make(map[string]*imageJob)
+	b.imageDeps = make(map[string]map[string]string)
 
-	// Process all content files
+	// Read and parse all content files
 	if err := b.processContent(); err != nil {
 		return err
 	}
 
+	// Group pages into sections, now that every _index.md is known
+	if err := b.buildSections(); err != nil {
+		return err
+	}
+
+	// Index pages by source file for links between them
+	if err := b.indexSources(); err != nil {
+		return err
+	}
+
+	// Run shortcodes, which may use any page or section
+	if err := b.runShortcodes(); err != nil {
+		return err
+	}
+
+	// Point links to markdown files at their pages
+	if err := b.resolveRefs(); err != nil {
+		return err
+	}
+
+	// Summaries are taken from the finished bodies
+	b.summarizePages()
+
+	// Draw preview images for posts without one
+	if err := b.generateCards(); err != nil {
+		return err
+	}
+
 	// Copy static files
 	if err := b.copyStaticFiles(); err != nil {
 		return err
 	}
 
-	// Generate index/archive pages
-	if err := b.generateIndexPages(); err != nil {
+	// Generate the stylesheet for highlighted code blocks
+	if err := b.generateHighlightCSS(); err != nil {
+		return err
+	}
+
+	// Minify and fingerprint CSS and JS for templates to link to
+	if err := b.buildAssets(); err != nil {
+		return err
+	}
+
+	// Render and write content pages
+	if err := b.renderPages(); err != nil {
+		return err
+	}
+
+	// Redirect old URLs of pages
+	if err := b.writeAliases(); err != nil {
+		return err
+	}
+
+	// Generate section list pages
+	if err := b.generateSectionPages(); err != nil {
 		return err
 	}
 
@@ -57,55 +156,195 @@ func (b *Builder) Build() error {
 		return err
 	}
 
+	// Generate tag, category and custom taxonomy pages
+	if err := b.generateTaxonomies(); err != nil {
+		return err
+	}
+
+	// Generate RSS, Atom and JSON feeds
+	if err := b.generateFeeds(); err != nil {
+		return err
+	}
+
+	// Generate sitemap.xml and robots.txt
+	if err := b.generateSitemap(); err != nil {
+		return err
+	}
+	if err := b.generateRobots(); err != nil {
+		return err
+	}
+
+	// Remove outputs of previous builds that no longer exist
+	if err := b.pruneOutputDir(); err != nil {
+		return fmt.Errorf("failed to clean output: %w", err)
+	}
+
+	if err := b.saveManifest(); err != nil {
+		return err
+	}
+
 	log.Printf("Build complete! Generated %d pages", len(b.site.Pages))
 	return nil
 }
 
-func (b *Builder) cleanOutputDir() error {
-	// Skip if output dir doesn't exist
-	if _, err := os.Stat(b.site.OutputDir); os.IsNotExist(err) {
-		return os.MkdirAll(b.site.OutputDir, 0755)
+func (b *Builder) processContent() error {
+	done := make(chan struct{})
+	paths, walkErr := b.walkContent(done)
+
+	workers := b.workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Each worker reads and parses one file at a time
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if err := b.processMarkdownFile(path); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
 	}
 
-	log.Printf("Cleaning output directory: %s", b.site.OutputDir)
+	wg.Wait()
+	close(done)
+	close(errs)
 
-	entries, err := os.ReadDir(b.site.OutputDir)
-	if err != nil {
+	if err := <-errs; err != nil {
+		return err
+	}
+	if err := <-walkErr; err != nil {
 		return err
 	}
 
-	for _, entry := range entries {
-		// Preserve .git directory if it exists
-		if entry.Name() == ".git" {
-			continue
-		}
+	b.sortPages()
+	return nil
+}
+
+// walkContent sends every markdown file under InputDir on the returned
+// channel. The walk stops early when done is closed.
+func (b *Builder) walkContent(done <-chan struct{}) (<-chan string, <-chan error) {
+	paths := make(chan string)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(paths)
+		errc <- filepath.Walk(b.site.InputDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
 
-		path := filepath.Join(b.site.OutputDir, entry.Name())
-		if err := os.RemoveAll(path); err != nil {
-			return fmt.Errorf("failed to remove %s: %w", path, err)
+			if info.IsDir() {
+				return nil
+			}
+
+			// Only process markdown files
+			if !strings.HasSuffix(strings.ToLower(info.Name()), ".md") {
+				return nil
+			}
+
+			select {
+			case paths <- path:
+				return nil
+			case <-done:
+				return filepath.SkipAll
+			}
+		})
+	}()
+
+	return paths, errc
+}
+
+// sortPages orders Pages by source path so the result does not depend on
+// worker scheduling.
+func (b *Builder) sortPages() {
+	sort.Slice(b.site.Pages, func(i, j int) bool {
+		return b.site.Pages[i].Path < b.site.Pages[j].Path
+	})
+}
+
+// renderPages renders and writes every content page with a pool of
+// workers. The home page is written by generateHomePage once recent posts
+// are known.
+func (b *Builder) renderPages() error {
+	workers := b.workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	done := make(chan struct{})
+	pages := make(chan *site.Page)
+	go func() {
+		defer close(pages)
+		for _, page := range b.site.Pages {
+			select {
+			case pages <- page:
+			case <-done:
+				return
+			}
 		}
+	}()
+
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range pages {
+				if err := b.renderPage(page); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
 	}
 
-	return nil
+	wg.Wait()
+	close(done)
+	close(errs)
+
+	return <-errs
 }
 
-func (b *Builder) processContent() error {
-	return filepath.Walk(b.site.InputDir, func(path string, info os.FileInfo, err error) error {
+func (b *Builder) renderPage(page *site.Page) error {
+	if page.Permalink == "/" {
+		return nil
+	}
+
+	outputPath := filepath.Join(b.site.OutputDir, filepath.FromSlash(page.Permalink), "index.html")
+	key := hashStrings(b.key, page.Path, b.sourceHash(page), b.sectionKey, b.refsKey, b.shortcodesKey(page))
+	written, err := b.writeOutput(outputPath, key, func() ([]byte, error) {
+		html, err := b.renderer.Render(*page)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("failed to render %s: %w", page.Path, err)
 		}
+		return html, nil
+	})
+	if err != nil {
+		return err
+	}
 
-		if info.IsDir() {
-			return nil
-		}
+	if written {
+		log.Printf("Generated: %s", outputPath)
+	}
+	return nil
+}
 
-		// Only process markdown files
-		if strings.HasSuffix(strings.ToLower(info.Name()), ".md") {
-			return b.processMarkdownFile(path)
+// summarizePages gives pages without a manual summary the start of their
+// text
+func (b *Builder) summarizePages() {
+	for _, page := range b.site.Pages {
+		if page.Summary == "" {
+			page.Summary, page.SummaryHTML, page.Truncated = parser.Summarize(page.Body, b.site.Config.SummaryLength)
 		}
-
-		return nil
-	})
+	}
 }
 
 func (b *Builder) processMarkdownFile(path string) error {
@@ -115,11 +354,17 @@ func (b *Builder) processMarkdownFile(path string) error {
 		return fmt.Errorf("failed to read %s: %w", path, err)
 	}
 
-	// Parse markdown
-	page, err := parser.Parse(path, data)
-	if err != nil {
-		return fmt.Errorf("failed to parse %s: %w", path, err)
+	// Parse markdown unless the previous build already parsed the same
+	// source with the same settings
+	hash := hashStrings(b.key, hashBytes(data))
+	page, ok := b.cachedParse(path, hash, data)
+	if !ok {
+		page, err = b.parser.Parse(path, data)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", path, err)
+		}
 	}
+	b.rememberPage(path, hash, page)
 
 	// Skip drafts
 	if page.Draft {
@@ -127,120 +372,117 @@ func (b *Builder) processMarkdownFile(path string) error {
 		return nil
 	}
 
+	// Skip pages outside their publishing window
+	now := time.Now()
+	if !page.PublishDate.IsZero() && page.PublishDate.After(now) {
+		log.Printf("Skipping scheduled page: %s", page.Title)
+		return nil
+	}
+	if !page.ExpiryDate.IsZero() && !page.ExpiryDate.After(now) {
+		log.Printf("Skipping expired page: %s", page.Title)
+		return nil
+	}
+
 	// Calculate output path
 	relPath, err := filepath.Rel(b.site.InputDir, path)
 	if err != nil {
 		return fmt.Errorf("failed to get relative path: %w", err)
 	}
+	relPath = filepath.ToSlash(relPath)
+
+	// Pages belong to the section of their directory
+	section := pathpkg.Dir(relPath)
+	if section == "." {
+		section = ""
+	}
+
+	// _index.md describes its section instead of being a page
+	if strings.EqualFold(pathpkg.Base(relPath), "_index.md") {
+		b.mu.Lock()
+		b.indexes[section] = &page
+		b.mu.Unlock()
+		return nil
+	}
+
+	// Check the front matter against the section's schema
+	if schema, ok := b.schema(section); ok {
+		if err := parser.Validate(path, data, page.Metadata, schema); err != nil {
+			return err
+		}
+	}
 
 	// Create clean URL structure
-	baseName := strings.TrimSuffix(relPath, ".md")
+	baseName := strings.TrimSuffix(relPath, pathpkg.Ext(relPath))
+	if page.Slug != "" && pathpkg.Base(baseName) != "index" {
+		baseName = pathpkg.Join(pathpkg.Dir(baseName), page.Slug)
+	}
 
-	var outputDir, outputPath, permalink string
-	if filepath.Base(baseName) == "index" {
+	var permalink string
+	switch {
+	case baseName == "index":
 		// Root index
-		outputDir = b.site.OutputDir
-		outputPath = filepath.Join(outputDir, "index.html")
 		permalink = "/"
-	} else {
+	case pathpkg.Base(baseName) == "index":
+		// Directory index
+		permalink = "/" + pathpkg.Dir(baseName) + "/"
+	default:
 		// Regular page
-		outputDir = filepath.Join(b.site.OutputDir, baseName)
-		outputPath = filepath.Join(outputDir, "index.html")
 		permalink = "/" + baseName + "/"
 	}
 
 	// Set page metadata
 	page.Permalink = permalink
+	page.Section = section
 	page.SiteName = b.site.SiteName
 	page.BaseURL = b.site.BaseURL
-
-	// Determine template based on directory
-	dir := filepath.Dir(relPath)
-	if page.TemplateName == "" {
-		switch dir {
-		case "blog":
-			page.TemplateName = "post.html"
-		default:
-			page.TemplateName = "page.html"
-		}
-	}
-
-	// Render page
-	html, err := b.renderer.Render(page)
-	if err != nil {
-		return fmt.Errorf("failed to render %s: %w", path, err)
-	}
-
-	// Create output directory
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", outputDir, err)
-	}
-
-	// Write HTML file
-	if err := os.WriteFile(outputPath, html, 0644); err != nil {
-		return fmt.Errorf("failed to write %s: %w", outputPath, err)
-	}
+	page.Site = b.site
+	b.assignTerms(&page)
 
 	// Store the page
+	b.mu.Lock()
 	b.site.Pages = append(b.site.Pages, &page)
+	b.mu.Unlock()
 
-	// Add to collections based on directory
-	if dir == "." {
-		dir = "pages"
-	}
-	b.site.Collections[dir] = append(b.site.Collections[dir], &page)
-
-	log.Printf("Generated: %s", outputPath)
 	return nil
 }
 
-func (b *Builder) generateIndexPages() error {
-	// Generate blog index page if we have blog posts
-	if posts, exists := b.site.Collections["blog"]; exists && len(posts) > 0 {
-		// Sort posts by date (newest first)
-		sort.Slice(posts, func(i, j int) bool {
-			return posts[i].Date.After(posts[j].Date)
-		})
-
-		// Generate summaries for posts (first 150 chars of raw markdown)
-		for _, post := range posts {
-			if len(post.RawBody) > 150 {
-				post.Summary = strings.TrimSpace(post.RawBody[:150]) + "..."
-			} else {
-				post.Summary = strings.TrimSpace(post.RawBody)
-			}
+// schema returns the front matter schema of a section, inherited from the
+// closest parent section that has one
+func (b *Builder) schema(section string) (site.SchemaConfig, bool) {
+	for {
+		if schema, ok := b.site.Config.Schemas[section]; ok {
+			return schema, true
 		}
-
-		// Create blog index page
-		blogIndex := site.Page{
-			Title:        "Blog",
-			Body:         "", // Not used for list template
-			TemplateName: "list.html",
-			SiteName:     b.site.SiteName,
-			BaseURL:      b.site.BaseURL,
-			Permalink:    "/blog/",
-			Pages:        posts, // Pass posts to the template
+		if section == "" {
+			return site.SchemaConfig{}, false
 		}
-
-		// Render and write blog index
-		html, err := b.renderer.Render(blogIndex)
-		if err != nil {
-			return fmt.Errorf("failed to render blog index: %w", err)
+		section = pathpkg.Dir(section)
+		if section == "." {
+			section = ""
 		}
+	}
+}
 
-		blogDir := filepath.Join(b.site.OutputDir, "blog")
-		if err := os.MkdirAll(blogDir, 0755); err != nil {
-			return fmt.Errorf("failed to create blog directory: %w", err)
-		}
+// writeListPage renders a generated list page to its permalink and
+// records it for the sitemap
+func (b *Builder) writeListPage(page *site.Page, key string) error {
+	b.lists = append(b.lists, page)
 
-		blogIndexPath := filepath.Join(blogDir, "index.html")
-		if err := os.WriteFile(blogIndexPath, html, 0644); err != nil {
-			return fmt.Errorf("failed to write blog index: %w", err)
+	outputPath := filepath.Join(b.site.OutputDir, filepath.FromSlash(page.Permalink), "index.html")
+	written, err := b.writeOutput(outputPath, key, func() ([]byte, error) {
+		html, err := b.renderer.Render(*page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", page.Permalink, err)
 		}
-
-		log.Printf("Generated blog index with %d posts: %s", len(posts), blogIndexPath)
+		return html, nil
+	})
+	if err != nil {
+		return err
 	}
 
+	if written {
+		log.Printf("Generated list with %d pages: %s", len(page.Pages), outputPath)
+	}
 	return nil
 }
 
@@ -271,27 +513,45 @@ func (b *Builder) copyStaticFiles() error {
 		// Destination path
 		destPath := filepath.Join(b.site.OutputDir, relPath)
 
-		// Create destination directory
-		destDir := filepath.Dir(destPath)
-		if err := os.MkdirAll(destDir, 0755); err != nil {
-			return err
-		}
-
-		// Copy file
+		// Copy file when its content changed
 		data, err := os.ReadFile(path)
 		if err != nil {
 			return err
 		}
 
-		if err := os.WriteFile(destPath, data, 0644); err != nil {
+		written, err := b.writeOutput(destPath, hashBytes(data), func() ([]byte, error) {
+			return data, nil
+		})
+		if err != nil {
 			return err
 		}
 
-		log.Printf("Copied static file: %s", destPath)
+		if written {
+			log.Printf("Copied static file: %s", destPath)
+		}
 		return nil
 	})
 }
 
+// generateHighlightCSS writes css/syntax.css for the configured
+// highlighting styles. A css/syntax.css in the static directory takes
+// precedence.
+func (b *Builder) generateHighlightCSS() error {
+	cfg := b.site.Config.Markup.Highlight
+	if !cfg.Enabled {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(b.site.StaticDir, "css", "syntax.css")); err == nil {
+		return nil
+	}
+
+	css, err := parser.HighlightCSS(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to generate syntax.css: %w", err)
+	}
+	return b.writeGenerated("css/syntax.css", css)
+}
+
 func (b *Builder) generateHomePage() error {
 	// Find the home page (permalink "/")
 	var homePage *site.Page
@@ -307,35 +567,73 @@ func (b *Builder) generateHomePage() error {
 		return nil
 	}
 
-	// Get recent blog posts (max 5)
-	if posts, exists := b.site.Collections["blog"]; exists && len(posts) > 0 {
-		// Posts are already sorted newest first in generateIndexPages()
-		recentCount := 5
-		if len(posts) < recentCount {
-			recentCount = len(posts)
+	// Get the newest pages of the configured section
+	cfg := b.site.Config.Home
+	posts := append([]*site.Page(nil), b.site.Collections[cfg.Section]...)
+	sortByDate(posts)
+
+	if !cfg.Paginate {
+		if len(posts) > cfg.Recent {
+			posts = posts[:cfg.Recent]
 		}
-		recentPosts := posts[:recentCount]
+		key := hashStrings(b.key, homePage.Path, b.sourceHash(homePage), b.refsKey, b.membersKey(posts), b.shortcodesKey(homePage))
+		return b.writeHomePage(homePage, posts, key)
+	}
 
-		// Initialize Metadata map if nil
-		if homePage.Metadata == nil {
-			homePage.Metadata = make(map[string]interface{})
+	// Later pages are lists like any other, the first one is the home page
+	key := hashStrings(b.key, homePage.Path, b.sourceHash(homePage), b.refsKey, b.membersKey(posts), b.shortcodesKey(homePage))
+	return b.writePaginated(homePage, posts, cfg.Recent, key, func(page *site.Page, key string) error {
+		if page.Paginator.Number > 1 {
+			page.Metadata = withRecentPosts(page.Metadata, page.Pages)
+			return b.writeListPage(page, key)
 		}
-		homePage.Metadata["RecentPosts"] = recentPosts
+		return b.writeHomePage(page, page.Pages, key)
+	})
+}
 
-		// Re-render the home page with updated metadata
+// writeHomePage renders the home page listing recentPosts, only when it or
+// its recent posts changed
+func (b *Builder) writeHomePage(homePage *site.Page, recentPosts []*site.Page, key string) error {
+	if len(recentPosts) > 0 {
+		homePage.Metadata = withRecentPosts(homePage.Metadata, recentPosts)
+	}
+
+	outputPath := filepath.Join(b.site.OutputDir, "index.html")
+	written, err := b.writeOutput(outputPath, key, func() ([]byte, error) {
 		html, err := b.renderer.Render(*homePage)
 		if err != nil {
-			return fmt.Errorf("failed to re-render home page: %w", err)
-		}
-
-		// Write updated home page
-		outputPath := filepath.Join(b.site.OutputDir, "index.html")
-		if err := os.WriteFile(outputPath, html, 0644); err != nil {
-			return fmt.Errorf("failed to write updated home page: %w", err)
+			return nil, fmt.Errorf("failed to render home page: %w", err)
 		}
+		return html, nil
+	})
+	if err != nil {
+		return err
+	}
 
-		log.Printf("Updated home page with %d recent posts", len(recentPosts))
+	if written {
+		log.Printf("Generated home page with %d recent posts", len(recentPosts))
 	}
 
 	return nil
 }
+
+// withRecentPosts returns a copy of metadata with RecentPosts set, leaving
+// the map shared by other pagers untouched
+func withRecentPosts(metadata map[string]interface{}, posts []*site.Page) map[string]interface{} {
+	copied := make(map[string]interface{}, len(metadata)+1)
+	for k, v := range metadata {
+		copied[k] = v
+	}
+	copied["RecentPosts"] = posts
+	return copied
+}
+
+// membersKey identifies a list of pages by their paths and source hashes,
+// and by every page of the site for those with shortcodes
+func (b *Builder) membersKey(pages []*site.Page) string {
+	parts := make([]string, 0, 3*len(pages))
+	for _, page := range pages {
+		parts = append(parts, page.Path, b.sourceHash(page), b.shortcodesKey(page))
+	}
+	return hashStrings(parts...)
+}
@@ -2,10 +2,18 @@ package builder
 
 import (
 	"bytes"
+	"fmt"
+	"image"
+	"image/png"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/sporollan/site/internal/parser"
 	"github.com/sporollan/site/internal/renderer"
 	"github.com/sporollan/site/internal/site"
 )
@@ -120,6 +128,10 @@ Draft post.`,
 			name:    "list.html",
 			content: `<!DOCTYPE html><html><body><h1>{{.Title}}</h1>{{range .Pages}}<h2>{{.Title}}</h2>{{end}}</body></html>`,
 		},
+		{
+			name:    "terms.html",
+			content: `<!DOCTYPE html><html><body><h1>{{.Title}}</h1>{{range .Taxonomy.Terms}}<a href="{{.Permalink}}">{{.Name}} ({{.Count}})</a>{{end}}</body></html>`,
+		},
 	}
 
 	for _, tmpl := range templates {
@@ -380,3 +392,1307 @@ func TestBuilder_copyStaticFiles(t *testing.T) {
 		}
 	})
 }
+
+func TestBuilder_processContentOrdering(t *testing.T) {
+	s, r, _ := setupTestSite(t)
+
+	// Add enough posts that workers finish out of order
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(s.InputDir, "blog", fmt.Sprintf("extra-%02d.md", i))
+		content := fmt.Sprintf("---\ntitle: \"Extra %d\"\ndate: 2023-11-%02d\n---\nBody %d", i, i+1, i)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var want []string
+	for _, workers := range []int{1, 8} {
+		b := New(s, r, workers)
+		if err := b.Build(); err != nil {
+			t.Fatalf("Build() with %d workers error = %v", workers, err)
+		}
+
+		var got []string
+		for _, page := range s.Pages {
+			got = append(got, page.Path)
+		}
+
+		if len(got) != 23 {
+			t.Fatalf("Pages count = %d, want 23", len(got))
+		}
+		if len(s.Collections["blog"]) != 21 {
+			t.Errorf("blog collection = %d, want 21", len(s.Collections["blog"]))
+		}
+
+		if want == nil {
+			want = got
+			continue
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("Pages[%d] = %s with %d workers, want %s", i, got[i], workers, want[i])
+			}
+		}
+	}
+}
+
+func TestBuilder_incrementalBuild(t *testing.T) {
+	s, r, tmpDir := setupTestSite(t)
+	s.CacheDir = filepath.Join(tmpDir, ".cache")
+
+	if err := New(s, r, 4).Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Backdate every output so rewrites are detectable
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	outputs := []string{"index.html", "about/index.html", "blog/post1/index.html", "blog/index.html", "style.css"}
+	for _, file := range outputs {
+		if err := os.Chtimes(filepath.Join(s.OutputDir, file), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	modified := func(file string) bool {
+		info, err := os.Stat(filepath.Join(s.OutputDir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return !info.ModTime().Equal(old)
+	}
+
+	t.Run("unchanged inputs are not rewritten", func(t *testing.T) {
+		// A fresh builder must pick up the manifest from CacheDir
+		if err := New(s, r, 4).Build(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, file := range outputs {
+			if modified(file) {
+				t.Errorf("%s was rewritten without changes", file)
+			}
+		}
+	})
+
+	t.Run("changed post rewrites its list pages", func(t *testing.T) {
+		post := filepath.Join(s.InputDir, "blog", "post1.md")
+		content := "---\ntitle: \"First Post, Edited\"\ndate: 2023-10-01\n---\nEdited."
+		if err := os.WriteFile(post, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := New(s, r, 4).Build(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, file := range []string{"blog/post1/index.html", "blog/index.html"} {
+			if !modified(file) {
+				t.Errorf("%s should be regenerated", file)
+			}
+		}
+		for _, file := range []string{"about/index.html", "style.css"} {
+			if modified(file) {
+				t.Errorf("%s was rewritten without changes", file)
+			}
+		}
+	})
+
+	t.Run("removed sources are pruned", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(s.InputDir, "about.md")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := New(s, r, 4).Build(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := os.Stat(filepath.Join(s.OutputDir, "about")); !os.IsNotExist(err) {
+			t.Error("Output of removed page should be deleted")
+		}
+	})
+}
+
+func TestBuilder_cachedMetadata(t *testing.T) {
+	s, r, tmpDir := setupTestSite(t)
+	s.CacheDir = filepath.Join(tmpDir, ".cache")
+
+	files := map[string]string{
+		"blog/toml.md": "+++\ntitle = \"TOML\"\ndate = 2023-10-05\nn = 3\nupdated = 2024-01-02T10:00:00Z\n[extra]\nlist = [1, 2]\n+++\nBody.",
+		"blog/yaml.md": "---\ntitle: YAML\ndate: 2023-10-06\nn: 3\nratio: 0.5\nextra:\n  list: [1, 2]\n---\nBody.",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(s.InputDir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := New(s, r, 4).Build(); err != nil {
+		t.Fatal(err)
+	}
+	// A fresh builder reads the pages back from the manifest
+	b := New(s, r, 4)
+	if err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(s.InputDir, filepath.FromSlash(name))
+		if _, ok := b.prev.Pages[path]; !ok {
+			t.Fatalf("%s not cached", name)
+		}
+		fresh, err := parser.Parse(path, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, page := range s.Pages {
+			if page.Path == path && !reflect.DeepEqual(page.Metadata, fresh.Metadata) {
+				t.Errorf("%s cached metadata = %#v, want %#v", name, page.Metadata, fresh.Metadata)
+			}
+		}
+	}
+}
+
+func TestBuilder_generateFeeds(t *testing.T) {
+	s, r, _ := setupTestSite(t)
+	s.Author = "Test Author"
+	s.Config.Feeds.FullContent = true
+
+	if err := New(s, r, 4).Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{
+		"index.xml", "atom.xml", "feed.json",
+		"blog/index.xml", "blog/atom.xml", "blog/feed.json",
+		"tags/go/index.xml", "tags/test/feed.json",
+	} {
+		if _, err := os.Stat(filepath.Join(s.OutputDir, file)); err != nil {
+			t.Errorf("Expected feed not created: %s", file)
+		}
+	}
+
+	// Drafts never reach a feed
+	if _, err := os.Stat(filepath.Join(s.OutputDir, "tags", "ssg")); !os.IsNotExist(err) {
+		t.Error("Tags used only by drafts should not get a feed")
+	}
+
+	rss, err := os.ReadFile(filepath.Join(s.OutputDir, "blog", "index.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<link>https://example.com/blog/post1/</link>",
+		"&lt;h1 id=&#34;post-1&#34;&gt;Post 1",
+	} {
+		if !bytes.Contains(rss, []byte(want)) {
+			t.Errorf("blog RSS does not contain %q", want)
+		}
+	}
+
+	if len(s.Feeds) == 0 || s.Feeds[0].URL != "https://example.com/index.xml" {
+		t.Errorf("Feeds = %+v, want site RSS first", s.Feeds)
+	}
+}
+
+func TestBuilder_generateSitemap(t *testing.T) {
+	s, r, _ := setupTestSite(t)
+
+	hidden := filepath.Join(s.InputDir, "hidden.md")
+	if err := os.WriteFile(hidden, []byte("---\ntitle: Hidden\nsitemap: false\n---\nHidden."), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("single sitemap", func(t *testing.T) {
+		if err := New(s, r, 4).Build(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.OutputDir, "sitemap.xml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, want := range []string{
+			"<urlset",
+			"<loc>https://example.com/</loc>",
+			"<loc>https://example.com/about/</loc>",
+			"<loc>https://example.com/blog/</loc>",
+			"<loc>https://example.com/blog/post1/</loc>",
+			"<lastmod>2023-10-01</lastmod>",
+		} {
+			if !bytes.Contains(data, []byte(want)) {
+				t.Errorf("sitemap does not contain %q", want)
+			}
+		}
+
+		for _, unwanted := range []string{"/hidden/", "/blog/post2/"} {
+			if bytes.Contains(data, []byte(unwanted)) {
+				t.Errorf("sitemap should not contain %q", unwanted)
+			}
+		}
+	})
+
+	t.Run("sitemap index", func(t *testing.T) {
+		s.Config.Sitemap.MaxURLs = 2
+		defer func() { s.Config.Sitemap.MaxURLs = 50000 }()
+
+		if err := New(s, r, 4).Build(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(s.OutputDir, "sitemap.xml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// At most 2 URLs per file
+		for _, want := range []string{
+			"<sitemapindex",
+			"<loc>https://example.com/sitemap1.xml</loc>",
+			"<loc>https://example.com/sitemap2.xml</loc>",
+		} {
+			if !bytes.Contains(data, []byte(want)) {
+				t.Errorf("sitemap index does not contain %q", want)
+			}
+		}
+		chunk, err := os.ReadFile(filepath.Join(s.OutputDir, "sitemap1.xml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := bytes.Count(chunk, []byte("<url>")); n != 2 {
+			t.Errorf("sitemap1.xml has %d URLs, want 2", n)
+		}
+	})
+}
+
+func TestBuilder_generateRobots(t *testing.T) {
+	tests := []struct {
+		name        string
+		environment string
+		disallow    []string
+		want        []string
+	}{
+		{
+			name:        "development blocks crawlers",
+			environment: "development",
+			want:        []string{"Disallow: /\n", "Sitemap: https://example.com/sitemap.xml"},
+		},
+		{
+			name:        "production allows crawlers",
+			environment: "production",
+			want:        []string{"Allow: /\n", "Sitemap: https://example.com/sitemap.xml"},
+		},
+		{
+			name:        "production with disallowed paths",
+			environment: "production",
+			disallow:    []string{"/drafts/"},
+			want:        []string{"Disallow: /drafts/\n"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, r, _ := setupTestSite(t)
+			s.Environment = tt.environment
+			s.Config.Robots.Disallow = tt.disallow
+
+			if err := New(s, r, 4).Build(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(s.OutputDir, "robots.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, want := range tt.want {
+				if !bytes.Contains(data, []byte(want)) {
+					t.Errorf("robots.txt = %q, want it to contain %q", data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBuilder_generateTaxonomies(t *testing.T) {
+	s, r, _ := setupTestSite(t)
+	s.Config.Taxonomies = append(s.Config.Taxonomies, site.TaxonomyConfig{Name: "series"})
+
+	post := filepath.Join(s.InputDir, "blog", "post3.md")
+	content := `---
+title: "Third Post"
+date: 2023-10-03
+tags: ["Go", "web"]
+categories: ["Notes"]
+series: "Building a Site"
+---
+Third.`
+	if err := os.WriteFile(post, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := New(s, r, 4).Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	tags := s.Taxonomies["tags"]
+	if tags == nil {
+		t.Fatal("tags taxonomy missing")
+	}
+
+	// "go" and "Go" share a term named after its first use; the draft's tags are ignored
+	var got []string
+	for _, term := range tags.Terms {
+		got = append(got, fmt.Sprintf("%s:%d", term.Slug, term.Count()))
+	}
+	if want := "go:2 test:1 web:1"; fmt.Sprint(strings.Join(got, " ")) != want {
+		t.Errorf("tag terms = %v, want %v", got, want)
+	}
+
+	if pages := tags.Terms[0].Pages; pages[0].Title != "Third Post" {
+		t.Errorf("term pages not sorted newest first: %s", pages[0].Title)
+	}
+
+	for _, file := range []string{
+		"tags/index.html",
+		"tags/go/index.html",
+		"categories/notes/index.html",
+		"series/building-a-site/index.html",
+	} {
+		if _, err := os.Stat(filepath.Join(s.OutputDir, file)); err != nil {
+			t.Errorf("Expected taxonomy page not created: %s", file)
+		}
+	}
+
+	index, err := os.ReadFile(filepath.Join(s.OutputDir, "tags", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(index, []byte(`<a href="/tags/go/">go (2)</a>`)) {
+		t.Errorf("tags index missing term count: %s", index)
+	}
+
+	var page *site.Page
+	for _, p := range s.Pages {
+		if p.Title == "Third Post" {
+			page = p
+		}
+	}
+	if page == nil || len(page.Terms["series"]) != 1 || page.Terms["series"][0].Permalink != "/series/building-a-site/" {
+		t.Errorf("page term links = %+v", page.Terms)
+	}
+}
+
+func TestBuilder_buildSections(t *testing.T) {
+	s, r, _ := setupTestSite(t)
+
+	files := map[string]string{
+		"blog/_index.md": `---
+title: "Writing"
+description: "All posts"
+template: post.html
+---
+Posts about things.`,
+		"docs/_index.md": `---
+sort: weight
+---`,
+		"docs/install.md":         "---\ntitle: \"Install\"\nweight: 2\n---\nInstall.",
+		"docs/intro.md":           "---\ntitle: \"Intro\"\nweight: 1\n---\nIntro.",
+		"docs/custom.md":          "---\ntitle: \"Custom\"\nweight: 3\ntemplate: page.html\n---\nCustom.",
+		"docs/guides/_index.md":   "---\ntitle: \"Guides\"\nsort: title\n---",
+		"docs/guides/zebra.md":    "---\ntitle: \"Zebra\"\n---\nZ.",
+		"docs/guides/antelope.md": "---\ntitle: \"antelope\"\n---\nA.",
+	}
+	for name, content := range files {
+		path := filepath.Join(s.InputDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := New(s, r, 4).Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	titles := func(pages []*site.Page) string {
+		var got []string
+		for _, page := range pages {
+			got = append(got, page.Title)
+		}
+		return strings.Join(got, ",")
+	}
+
+	tests := []struct {
+		section string
+		title   string
+		pages   string
+	}{
+		{"blog", "Writing", "First Post"},
+		{"docs", "Docs", "Intro,Install,Custom"},
+		{"docs/guides", "Guides", "antelope,Zebra"},
+	}
+	for _, tt := range tests {
+		sec := s.Sections[tt.section]
+		if sec == nil {
+			t.Errorf("section %s missing", tt.section)
+			continue
+		}
+		if sec.Title != tt.title {
+			t.Errorf("section %s title = %q, want %q", tt.section, sec.Title, tt.title)
+		}
+		if got := titles(sec.Pages); got != tt.pages {
+			t.Errorf("section %s pages = %s, want %s", tt.section, got, tt.pages)
+		}
+	}
+
+	if subs := s.Sections["docs"].Sections; len(subs) != 1 || subs[0].Name != "docs/guides" {
+		t.Errorf("docs subsections = %+v", subs)
+	}
+
+	// _index.md files are not pages
+	for _, page := range s.Pages {
+		if strings.HasSuffix(page.Path, "_index.md") {
+			t.Errorf("_index.md rendered as a page: %s", page.Path)
+		}
+	}
+
+	byTitle := make(map[string]*site.Page)
+	for _, page := range s.Pages {
+		byTitle[page.Title] = page
+	}
+
+	templates := map[string]string{
+		"First Post": "post.html",
+		"Intro":      "page.html",
+		"Custom":     "page.html",
+		"Home":       "home.html",
+	}
+	for title, want := range templates {
+		if got := byTitle[title].TemplateName; got != want {
+			t.Errorf("%s template = %s, want %s", title, got, want)
+		}
+	}
+
+	var crumbs []string
+	for _, crumb := range byTitle["Zebra"].Breadcrumbs {
+		crumbs = append(crumbs, crumb.Title+"="+crumb.Permalink)
+	}
+	if got, want := strings.Join(crumbs, " "), "Home=/ Docs=/docs/ Guides=/docs/guides/ Zebra=/docs/guides/zebra/"; got != want {
+		t.Errorf("breadcrumbs = %s, want %s", got, want)
+	}
+
+	// Every section gets a list page with its _index.md title
+	for file, want := range map[string]string{
+		"blog/index.html":        "<h1>Writing</h1><h2>First Post</h2>",
+		"docs/index.html":        "<h1>Docs</h1><h2>Intro</h2><h2>Install</h2>",
+		"docs/guides/index.html": "<h1>Guides</h1><h2>antelope</h2><h2>Zebra</h2>",
+	} {
+		data, err := os.ReadFile(filepath.Join(s.OutputDir, filepath.FromSlash(file)))
+		if err != nil {
+			t.Errorf("section list not created: %s", file)
+			continue
+		}
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("%s = %s, want it to contain %s", file, data, want)
+		}
+	}
+
+	t.Run("invalid sort", func(t *testing.T) {
+		path := filepath.Join(s.InputDir, "docs", "_index.md")
+		if err := os.WriteFile(path, []byte("---\nsort: random\n---"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := New(s, r, 4).Build()
+		if err == nil || !strings.Contains(err.Error(), "_index.md") {
+			t.Errorf("Build() error = %v, want unknown sort error naming _index.md", err)
+		}
+	})
+}
+
+func TestBuilder_pagination(t *testing.T) {
+	s, _, _ := setupTestSite(t)
+	s.Config.Pagination.PageSize = 10
+	s.Config.Home.Paginate = true
+	s.Config.Home.Recent = 20
+
+	// post1 plus 24 more gives three pages of blog posts
+	for i := 0; i < 24; i++ {
+		path := filepath.Join(s.InputDir, "blog", fmt.Sprintf("extra-%02d.md", i))
+		content := fmt.Sprintf("---\ntitle: \"Extra %d\"\ndate: 2023-11-%02d\ntags: [go]\n---\nBody %d", i, i+1, i)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both templates print the paginator
+	pager := `{{with .Paginator}}{{.Number}}/{{.TotalPages}} [{{.Prev}}|{{.Next}}] {{len .Pages}} of {{.TotalItems}}{{end}}`
+	for _, name := range []string{"list.html", "home.html"} {
+		if err := os.WriteFile(filepath.Join(s.TemplateDir, name), []byte(pager), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := renderer.New(s.TemplateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := New(s, r, 4).Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"blog/index.html", "1/3 [|/blog/page/2/] 10 of 25"},
+		{"blog/page/2/index.html", "2/3 [/blog/|/blog/page/3/] 10 of 25"},
+		{"blog/page/3/index.html", "3/3 [/blog/page/2/|] 5 of 25"},
+		{"tags/go/page/3/index.html", "3/3 [/tags/go/page/2/|] 5 of 25"},
+		{"page/2/index.html", "2/2 [/|] 5 of 25"},
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join(s.OutputDir, filepath.FromSlash(tt.file)))
+		if err != nil {
+			t.Errorf("paginated page not created: %s", tt.file)
+			continue
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s = %q, want %q", tt.file, data, tt.want)
+		}
+	}
+
+	// Page 1 redirects to the list itself
+	alias, err := os.ReadFile(filepath.Join(s.OutputDir, "blog", "page", "1", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(alias, []byte(`url=https://example.com/blog/`)) {
+		t.Errorf("page 1 alias = %s", alias)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.OutputDir, "blog", "page", "4")); !os.IsNotExist(err) {
+		t.Error("unexpected fourth blog page")
+	}
+
+	t.Run("section page size", func(t *testing.T) {
+		index := filepath.Join(s.InputDir, "blog", "_index.md")
+		if err := os.WriteFile(index, []byte("+++\ntitle = \"Blog\"\npaginate = 5\n+++\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := New(s, r, 4).Build(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(s.OutputDir, "blog", "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "1/5 [|/blog/page/2/] 5 of 25"; string(data) != want {
+			t.Errorf("blog/index.html = %q, want %q", data, want)
+		}
+
+		if err := os.WriteFile(index, []byte("---\npaginate: many\n---\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err = New(s, r, 4).Build()
+		if err == nil || !strings.Contains(err.Error(), "paginate: want an integer") {
+			t.Errorf("Build() error = %v, want paginate error", err)
+		}
+	})
+}
+
+func TestBuilder_frontMatter(t *testing.T) {
+	s, r, _ := setupTestSite(t)
+
+	files := map[string]string{
+		"blog/renamed.md":   "---\ntitle: \"Renamed\"\ndate: 2023-10-05\nslug: new-name\naliases: [/old-name/]\nlastmod: 2024-01-01\n---\nBody.",
+		"blog/scheduled.md": "---\ntitle: \"Scheduled\"\npublishDate: 2999-01-01\n---\nLater.",
+		"blog/expired.md":   "---\ntitle: \"Expired\"\ndate: 2020-01-01\nexpiryDate: 2021-01-01\n---\nGone.",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(s.InputDir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := New(s, r, 4).Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, page := range s.Pages {
+		if page.Title == "Scheduled" || page.Title == "Expired" {
+			t.Errorf("%s page published", page.Title)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(s.OutputDir, "blog", "new-name", "index.html")); err != nil {
+		t.Error("slug not used in permalink")
+	}
+
+	alias, err := os.ReadFile(filepath.Join(s.OutputDir, "old-name", "index.html"))
+	if err != nil {
+		t.Fatal("alias not written")
+	}
+	if !bytes.Contains(alias, []byte("https://example.com/blog/new-name/")) {
+		t.Errorf("alias = %s", alias)
+	}
+
+	sitemap, err := os.ReadFile(filepath.Join(s.OutputDir, "sitemap.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(sitemap, []byte("<lastmod>2024-01-01</lastmod>")) {
+		t.Error("sitemap does not use lastmod")
+	}
+
+	t.Run("section schema", func(t *testing.T) {
+		s.Config.Schemas = map[string]site.SchemaConfig{
+			"blog": {Required: []string{"date"}},
+		}
+		path := filepath.Join(s.InputDir, "blog", "undated.md")
+		if err := os.WriteFile(path, []byte("---\ntitle: \"Undated\"\n---\nBody."), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := New(s, r, 4).Build()
+		if err == nil || !strings.Contains(err.Error(), path+`:3: missing required field "date"`) {
+			t.Errorf("Build() error = %v, want missing date diagnostic", err)
+		}
+	})
+}
+
+func TestBuilder_shortcodes(t *testing.T) {
+	s, _, _ := setupTestSite(t)
+
+	shortcodeDir := filepath.Join(s.TemplateDir, "shortcodes")
+	if err := os.MkdirAll(shortcodeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	templates := map[string]string{
+		"count.html":  `<span class="count">{{len .Site.Pages}} pages, {{len (index .Site.Collections "blog")}} in blog</span>`,
+		"note.html":   `<aside>{{.Page.Permalink}}: {{.Inner}}</aside>`,
+		"titles.html": `<ul>{{range .Site.Pages}}<li>{{.Title}}</li>{{end}}</ul>`,
+	}
+	for name, content := range templates {
+		if err := os.WriteFile(filepath.Join(shortcodeDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Lists show the whole of their pages
+	s.Config.Feeds.FullContent = true
+	lists := map[string]string{
+		"list.html": `{{range .Pages}}{{.Body | safeHTML}}{{end}}`,
+		"home.html": `{{range .Metadata.RecentPosts}}{{.Body | safeHTML}}{{end}}`,
+	}
+	for name, content := range lists {
+		if err := os.WriteFile(filepath.Join(s.TemplateDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string]string{
+		"blog/notes.md":  "---\ntitle: \"Notes\"\ndate: 2023-10-03\ntags: [go]\n---\n{{< note >}}See **this**.{{< /note >}}\n\nThere are {{< count >}}.\n\n{{< titles >}}",
+		"blog/_index.md": "---\ntitle: \"Blog\"\n---\n{{< note >}}All posts{{< /note >}}",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(s.InputDir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := renderer.New(s.TemplateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := New(s, r, 4)
+	if err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := os.ReadFile(filepath.Join(s.OutputDir, "blog", "notes", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<aside>/blog/notes/: See <strong>this</strong>.</aside>",
+		`<span class="count">4 pages, 2 in blog</span>`,
+	} {
+		if !bytes.Contains(output, []byte(want)) {
+			t.Errorf("output = %s, want it to contain %s", output, want)
+		}
+	}
+
+	if got, want := s.Sections["blog"].Body, "<aside>/blog/: All posts</aside>"; !strings.Contains(got, want) {
+		t.Errorf("section body = %q, want it to contain %q", got, want)
+	}
+
+	for _, page := range s.Pages {
+		if page.Title == "Notes" && parser.HasShortcodes(page.Summary+page.SummaryHTML, page.Shortcodes) {
+			t.Errorf("summary = %q, placeholders left", page.SummaryHTML)
+		}
+	}
+
+	t.Run("output follows other pages", func(t *testing.T) {
+		// Same headings and permalink, a different title
+		path := filepath.Join(s.InputDir, "about.md")
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, bytes.Replace(source, []byte(`title: "About"`), []byte(`title: "About Me"`), 1), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.Build(); err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range []string{
+			"blog/notes/index.html",
+			"blog/index.html",
+			"tags/go/index.html",
+			"index.html",
+			"index.xml",
+			"blog/index.xml",
+		} {
+			output, err := os.ReadFile(filepath.Join(s.OutputDir, filepath.FromSlash(file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "<li>About Me</li>"
+			if strings.HasSuffix(file, ".xml") {
+				want = "&lt;li&gt;About Me&lt;/li&gt;"
+			}
+			if !bytes.Contains(output, []byte(want)) {
+				t.Errorf("%s = %s, want it to contain %s", file, output, want)
+			}
+		}
+	})
+
+	t.Run("unknown shortcode", func(t *testing.T) {
+		path := filepath.Join(s.InputDir, "blog", "broken.md")
+		if err := os.WriteFile(path, []byte("---\ntitle: \"Broken\"\n---\n\nText\n\n{{< nope >}}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := New(s, r, 4).Build()
+		if err == nil || !strings.Contains(err.Error(), path+`:7: unknown shortcode "nope"`) {
+			t.Errorf("Build() error = %v, want unknown shortcode diagnostic", err)
+		}
+	})
+}
+
+func TestBuilder_renderHooks(t *testing.T) {
+	s, _, _ := setupTestSite(t)
+
+	hookDir := filepath.Join(s.TemplateDir, "_hooks")
+	if err := os.MkdirAll(hookDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	hooks := map[string]string{
+		"link.html":  `<a href="{{.Destination}}"{{if .External}} rel="noopener" target="_blank"{{end}}>{{.Text | safeHTML}}</a>` + "\n",
+		"image.html": `<img src="{{.Destination}}" alt="{{.PlainText}}"{{with .Width}} width="{{.}}"{{end}}{{with .Height}} height="{{.}}"{{end}} loading="lazy">` + "\n",
+	}
+	for name, content := range hooks {
+		if err := os.WriteFile(filepath.Join(hookDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A 3x2 PNG in the static directory and one next to the post
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for _, path := range []string{
+		filepath.Join(s.StaticDir, "img", "static.png"),
+		filepath.Join(s.InputDir, "blog", "local.png"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	post := "---\ntitle: \"Hooks\"\ndate: 2023-10-03\n---\n" +
+		"[out](https://go.dev), [in](https://example.com/about/), [rel](/about/), [md](../about.md).\n\n" +
+		"![S](/img/static.png) ![L](local.png) ![R](https://example.org/r.png)\n"
+	if err := os.WriteFile(filepath.Join(s.InputDir, "blog", "hooks.md"), []byte(post), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := renderer.New(s.TemplateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := New(s, r, 4).Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := os.ReadFile(filepath.Join(s.OutputDir, "blog", "hooks", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<a href="https://go.dev" rel="noopener" target="_blank">out</a>, `,
+		`<a href="https://example.com/about/">in</a>`,
+		`<a href="/about/">rel</a>, `,
+		`<a href="/about/">md</a>.`,
+		`<img src="/img/static.png" alt="S" width="3" height="2" loading="lazy">`,
+		`<img src="local.png" alt="L" width="3" height="2" loading="lazy">`,
+		`<img src="https://example.org/r.png" alt="R" loading="lazy">`,
+	} {
+		if !bytes.Contains(output, []byte(want)) {
+			t.Errorf("output = %s, want it to contain %s", output, want)
+		}
+	}
+}
+
+func TestBuilder_refs(t *testing.T) {
+	s, _, _ := setupTestSite(t)
+	s.BaseURL = "https://example.com"
+
+	shortcodeDir := filepath.Join(s.TemplateDir, "shortcodes")
+	if err := os.MkdirAll(shortcodeDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	shortcode := `<a class="ref" href="{{relref . "about.md#about-me"}}">{{ref .Page "./post1.md"}}</a>`
+	if err := os.WriteFile(filepath.Join(shortcodeDir, "refs.html"), []byte(shortcode), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	links := "---\ntitle: \"Links\"\ndate: 2023-10-03\n---\nSee [me](../about.md#about-me) and [the first post](post1.md).\n\n{{< refs >}}"
+	if err := os.WriteFile(filepath.Join(s.InputDir, "blog", "links.md"), []byte(links), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := renderer.New(s.TemplateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := New(s, r, 4).Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := os.ReadFile(filepath.Join(s.OutputDir, "blog", "links", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<a href="/about/#about-me">me</a>`,
+		`<a href="/blog/post1/">the first post</a>`,
+		`<a class="ref" href="/about/#about-me">https://example.com/blog/post1/</a>`,
+	} {
+		if !bytes.Contains(output, []byte(want)) {
+			t.Errorf("output = %s, want it to contain %s", output, want)
+		}
+	}
+
+	// Automatic summaries are taken once links are resolved
+	for _, page := range s.Pages {
+		if page.Title == "Links" && !strings.Contains(page.SummaryHTML, `<a href="/about/#about-me">me</a>`) {
+			t.Errorf("summary = %q, want resolved links", page.SummaryHTML)
+		}
+	}
+
+	broken := filepath.Join(s.InputDir, "blog", "broken.md")
+	if err := os.WriteFile(broken, []byte("---\ntitle: \"Broken\"\n---\n\n[Gone](gone.md) and [nowhere](../about.md#nowhere)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("error", func(t *testing.T) {
+		err := New(s, r, 4).Build()
+		if err == nil {
+			t.Fatal("Build() succeeded with broken links")
+		}
+		for _, want := range []string{
+			broken + ":5: broken link gone.md: no published content file blog/gone.md",
+			broken + ":5: broken link ../about.md#nowhere: no heading #nowhere in about.md",
+		} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Build() error = %v, want it to contain %s", err, want)
+			}
+		}
+	})
+
+	t.Run("warning", func(t *testing.T) {
+		s.Config.Markup.BrokenRefs = "warning"
+		defer func() { s.Config.Markup.BrokenRefs = "error" }()
+
+		if err := New(s, r, 4).Build(); err != nil {
+			t.Fatalf("Build() error = %v, want only warnings", err)
+		}
+		output, err := os.ReadFile(filepath.Join(s.OutputDir, "blog", "broken", "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `<a href="gone.md">Gone</a>`; !bytes.Contains(output, []byte(want)) {
+			t.Errorf("output = %s, want it to contain %s", output, want)
+		}
+	})
+
+	t.Run("lists follow moved targets", func(t *testing.T) {
+		if err := os.Remove(broken); err != nil {
+			t.Fatal(err)
+		}
+		files := map[string]string{
+			"index.md":      "---\ntitle: \"Home\"\ntemplate: \"home.html\"\n---\nSee [about](about.md).",
+			"blog/links.md": "---\ntitle: \"Links\"\ndate: 2023-10-03\ntags: [go]\n---\nSee [me](../about.md#about-me).",
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(s.InputDir, filepath.FromSlash(name)), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		list := `{{range .Pages}}{{.SummaryHTML | safeHTML}}{{end}}`
+		if err := os.WriteFile(filepath.Join(s.TemplateDir, "list.html"), []byte(list), 0644); err != nil {
+			t.Fatal(err)
+		}
+		r, err := renderer.New(s.TemplateDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b := New(s, r, 4)
+		if err := b.Build(); err != nil {
+			t.Fatal(err)
+		}
+		about := "---\ntitle: \"About\"\nslug: info\n---\n\n# About Me\n\nAbout page content."
+		if err := os.WriteFile(filepath.Join(s.InputDir, "about.md"), []byte(about), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Build(); err != nil {
+			t.Fatal(err)
+		}
+
+		for file, want := range map[string]string{
+			"index.html":            `href="/info/"`,
+			"index.xml":             `/info/#about-me`,
+			"tags/go/index.html":    `href="/info/#about-me"`,
+			"blog/index.html":       `href="/info/#about-me"`,
+			"blog/links/index.html": `href="/info/#about-me"`,
+		} {
+			data, err := os.ReadFile(filepath.Join(s.OutputDir, filepath.FromSlash(file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(data, []byte(want)) {
+				t.Errorf("%s = %s, want it to contain %s", file, data, want)
+			}
+		}
+	})
+}
+
+func TestBuilder_cards(t *testing.T) {
+	s, r, _ := setupTestSite(t)
+	s.Config.Cards.Enabled = true
+
+	withImage := "---\ntitle: \"Pictured\"\ndate: 2023-10-04\nimage: /img/cover.png\n---\nText."
+	if err := os.WriteFile(filepath.Join(s.InputDir, "blog", "pictured.md"), []byte(withImage), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := New(s, r, 4)
+	if err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	cardPath := filepath.Join(s.OutputDir, "blog", "post1", "og.png")
+	data, err := os.ReadFile(cardPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img, err := png.DecodeConfig(bytes.NewReader(data)); err != nil || img.Width != 1200 || img.Height != 630 {
+		t.Errorf("card = %+v, %v, want a 1200x630 PNG", img, err)
+	}
+
+	images := make(map[string]string)
+	for _, page := range s.Pages {
+		images[page.Title] = page.Image
+	}
+	if images["First Post"] != "/blog/post1/og.png" || images["Pictured"] != "/img/cover.png" || images["About"] != "" {
+		t.Errorf("page images = %v", images)
+	}
+	if _, err := os.Stat(filepath.Join(s.OutputDir, "blog", "pictured", "og.png")); !os.IsNotExist(err) {
+		t.Errorf("card drawn for a page with an image: %v", err)
+	}
+
+	// Cards are kept while what they show is unchanged
+	if err := os.WriteFile(cardPath, []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	post := filepath.Join(s.InputDir, "blog", "post1.md")
+	source, err := os.ReadFile(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(post, append(source, "\n\nMore text."...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(cardPath); string(data) != "kept" {
+		t.Error("card redrawn after a body change")
+	}
+
+	if err := os.WriteFile(post, bytes.Replace(source, []byte("First Post"), []byte("Renamed Post"), 1), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(cardPath); string(data) == "kept" {
+		t.Error("card not redrawn after a title change")
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		s.Config.Cards.Enabled = false
+		defer func() { s.Config.Cards.Enabled = true }()
+
+		if err := New(s, r, 4).Build(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(cardPath); !os.IsNotExist(err) {
+			t.Errorf("card left after disabling cards: %v", err)
+		}
+	})
+}
+
+func TestBuilder_assets(t *testing.T) {
+	s, _, _ := setupTestSite(t)
+	s.Config.Assets.Minify = true
+	s.Config.Assets.Fingerprint = true
+
+	for name, content := range map[string]string{
+		"js/a.js": "function greet ( name ) {\n  return 'hello ' + name;\n}\n",
+		"js/b.js": "greet( 'world' );\n",
+	} {
+		path := filepath.Join(s.StaticDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	home := `<html><head>{{with asset "/style.css"}}<link href="{{.URL}}" integrity="{{.Integrity}}">{{end}}</head><body>{{.Body | safeHTML}}</body></html>`
+	if err := os.WriteFile(filepath.Join(s.TemplateDir, "home.html"), []byte(home), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := renderer.New(s.TemplateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Config.Assets.Bundles = map[string][]string{"js/all.js": {"js/a.js", "js/b.js"}}
+
+	b := New(s, r, 4)
+	if err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+
+	style := s.Assets["style.css"]
+	if style == nil {
+		t.Fatalf("assets = %v, want style.css", s.Assets)
+	}
+	if style.URL != "/"+fingerprint("style.css", []byte("body{color:red}")) {
+		t.Errorf("style.css URL = %q", style.URL)
+	}
+	data, err := os.ReadFile(filepath.Join(s.OutputDir, filepath.FromSlash(style.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "body{color:red}" || style.Integrity != integrity(data) {
+		t.Errorf("style.css = %q, integrity %q", data, style.Integrity)
+	}
+	if _, err := os.Stat(filepath.Join(s.OutputDir, "style.css")); err != nil {
+		t.Errorf("original style.css removed: %v", err)
+	}
+
+	bundle := s.Assets["js/all.js"]
+	if bundle == nil {
+		t.Fatalf("assets = %v, want js/all.js", s.Assets)
+	}
+	data, err = os.ReadFile(filepath.Join(s.OutputDir, filepath.FromSlash(bundle.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); !strings.Contains(got, "hello") || !strings.Contains(got, "world") || strings.Contains(got, "  ") {
+		t.Errorf("js/all.js = %q, want both files minified", got)
+	}
+
+	manifest, err := os.ReadFile(filepath.Join(s.OutputDir, assetManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(manifest), `"url": "`+bundle.URL+`"`) {
+		t.Errorf("%s = %s", assetManifestName, manifest)
+	}
+
+	page, err := os.ReadFile(filepath.Join(s.OutputDir, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), `<link href="`+style.URL+`"`) || !strings.Contains(string(page), "integrity=\"sha384-") {
+		t.Errorf("home page does not link to the fingerprinted stylesheet:\n%s", page)
+	}
+
+	// Pages follow the fingerprint when a stylesheet changes
+	if err := os.WriteFile(filepath.Join(s.StaticDir, "style.css"), []byte("body { color: blue; }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+	page, _ = os.ReadFile(filepath.Join(s.OutputDir, "index.html"))
+	if changed := s.Assets["style.css"].URL; changed == style.URL || !strings.Contains(string(page), changed) {
+		t.Errorf("home page not updated for %s:\n%s", changed, page)
+	}
+	if _, err := os.Stat(filepath.Join(s.OutputDir, filepath.FromSlash(style.URL))); !os.IsNotExist(err) {
+		t.Errorf("stale %s kept: %v", style.URL, err)
+	}
+
+	// Bundles only take files of their own type
+	s.Config.Assets.Bundles = map[string][]string{"js/all.js": {"style.css"}}
+	if err := b.Build(); err == nil || !strings.Contains(err.Error(), "assets.bundles") {
+		t.Errorf("Build() error = %v, want a bad bundle", err)
+	}
+
+	// Templates can't link to files that aren't assets
+	home = `<html><head>{{with asset "missing.css"}}{{.URL}}{{end}}</head></html>`
+	if err := os.WriteFile(filepath.Join(s.TemplateDir, "home.html"), []byte(home), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if b.renderer, err = renderer.New(s.TemplateDir); err != nil {
+		t.Fatal(err)
+	}
+	s.Config.Assets.Bundles = nil
+	if err := b.Build(); err == nil || !strings.Contains(err.Error(), "unknown asset") {
+		t.Errorf("Build() error = %v, want an unknown asset", err)
+	}
+}
+
+func TestBuilder_images(t *testing.T) {
+	s, r, _ := setupTestSite(t)
+	s.Config.Images.Enabled = true
+	s.Config.Images.Widths = []int{4, 8, 16}
+	s.Config.Images.Sizes = "100vw"
+
+	writeImage := func(path string, w, h int) {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	static := filepath.Join(s.StaticDir, "img", "wide.png")
+	writeImage(static, 10, 6)
+	writeImage(filepath.Join(s.InputDir, "blog", "local.png"), 3, 2)
+	if err := os.WriteFile(filepath.Join(s.StaticDir, "img", "logo.svg"), []byte("<svg/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	post := "---\ntitle: \"Images\"\n---\n![Wide](/img/wide.png \"A title\") ![Local](local.png) ![Logo](/img/logo.svg)\n"
+	if err := os.WriteFile(filepath.Join(s.InputDir, "blog", "images.md"), []byte(post), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s.CacheDir = filepath.Join(t.TempDir(), "cache")
+	build := func() string {
+		t.Helper()
+		if err := New(s, r, 4).Build(); err != nil {
+			t.Fatal(err)
+		}
+		output, err := os.ReadFile(filepath.Join(s.OutputDir, "blog", "images", "index.html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(output)
+	}
+	output := build()
+
+	wide := regexp.MustCompile(`<img src="(/img/wide\.[0-9a-f]{8})-10w\.png" alt="Wide" title="A title" srcset="([^"]*)" sizes="100vw" width="10" height="6" loading="lazy" decoding="async">`).FindStringSubmatch(output)
+	if wide == nil {
+		t.Fatalf("output = %s, want a responsive wide.png", output)
+	}
+	if want := fmt.Sprintf("%[1]s-4w.png 4w, %[1]s-8w.png 8w, %[1]s-10w.png 10w", wide[1]); wide[2] != want {
+		t.Errorf("srcset = %q, want %q", wide[2], want)
+	}
+	for width, height := range map[int]int{4: 2, 8: 5, 10: 6} {
+		data, err := os.ReadFile(filepath.Join(s.OutputDir, filepath.FromSlash(fmt.Sprintf("%s-%dw.png", wide[1], width))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg, err := png.DecodeConfig(bytes.NewReader(data)); err != nil || cfg.Width != width || cfg.Height != height {
+			t.Errorf("%dw copy = %+v, %v, want %dx%d", width, cfg, err, width, height)
+		}
+	}
+	for _, want := range []string{
+		`<img src="/blog/local.`,
+		`-3w.png 3w" sizes="100vw" width="3" height="2"`,
+		`<img src="/img/logo.svg" alt="Logo" loading="lazy" decoding="async">`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output = %s, want it to contain %s", output, want)
+		}
+	}
+
+	// Unchanged images aren't processed again
+	kept := filepath.Join(s.OutputDir, filepath.FromSlash(wide[1]+"-4w.png"))
+	if err := os.WriteFile(kept, []byte("kept"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if build() != output {
+		t.Error("output changed without changes to the sources")
+	}
+	if data, _ := os.ReadFile(kept); string(data) != "kept" {
+		t.Error("copy remade from an unchanged image")
+	}
+
+	// Pages follow a changed image even though their source is the same
+	writeImage(static, 20, 10)
+	output = build()
+	if !strings.Contains(output, `-16w.png 16w`) || !strings.Contains(output, `width="20" height="10"`) {
+		t.Errorf("output = %s, want the new size", output)
+	}
+	if _, err := os.Stat(kept); !os.IsNotExist(err) {
+		t.Errorf("copy of the old image kept: %v", err)
+	}
+
+	// Broken images fail the build at their line
+	if err := os.WriteFile(static, []byte("not a png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(s, r, 4).Build(); err == nil || !strings.Contains(err.Error(), "images.md:4") {
+		t.Errorf("Build() error = %v, want one at images.md:4", err)
+	}
+}
+
+func TestDescribeType(t *testing.T) {
+	type node struct {
+		Name     string
+		Children []*node
+		Parent   *node `json:"-"`
+		Meta     map[string]site.Shortcode
+	}
+	got := describeType(reflect.TypeOf(node{}), make(map[reflect.Type]bool))
+	for _, want := range []string{"Name string", "Children slice ptr builder.node;", "Placeholder string"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("describeType() = %q, want it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "Parent") {
+		t.Errorf("describeType() = %q, want fields left out of JSON skipped", got)
+	}
+}
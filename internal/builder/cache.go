@@ -0,0 +1,351 @@
+package builder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sporollan/site/internal/parser"
+	"github.com/sporollan/site/internal/site"
+	"gopkg.in/yaml.v2"
+)
+
+// cacheVersion is bumped whenever the builder changes how outputs are
+// produced, so manifests written by older builds are discarded.
+const cacheVersion = 4
+
+// pageFormat describes site.Page and the types it holds. Pages are cached
+// whole, so any change to their fields must drop them.
+var pageFormat = describeType(reflect.TypeOf(site.Page{}), make(map[reflect.Type]bool))
+
+// describeType lists the fields of t and of the types it holds, except
+// those left out of JSON
+func describeType(t reflect.Type, seen map[reflect.Type]bool) string {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array:
+		return t.Kind().String() + " " + describeType(t.Elem(), seen)
+	case reflect.Map:
+		return "map " + describeType(t.Key(), seen) + " " + describeType(t.Elem(), seen)
+	case reflect.Struct:
+		if seen[t] {
+			return t.String()
+		}
+		seen[t] = true
+
+		var b strings.Builder
+		b.WriteString(t.String() + " {")
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Tag.Get("json") == "-" {
+				continue
+			}
+			b.WriteString(f.Name + " " + describeType(f.Type, seen) + "; ")
+		}
+		b.WriteString("}")
+		return b.String()
+	}
+	return t.String()
+}
+
+const manifestName = "manifest.json"
+
+// manifest records what a build read and wrote. The next build uses it to
+// skip parsing unchanged sources and rendering unchanged outputs.
+type manifest struct {
+	Version int                   `json:"version"`
+	Pages   map[string]cachedPage `json:"pages"`   // keyed by source path
+	Outputs map[string]string     `json:"outputs"` // output path -> input key
+}
+
+type cachedPage struct {
+	Hash   string            `json:"hash"`
+	Page   site.Page         `json:"page"`
+	Images map[string]string `json:"images,omitempty"` // image file -> key of its copies
+}
+
+func newManifest() *manifest {
+	return &manifest{
+		Version: cacheVersion,
+		Pages:   make(map[string]cachedPage),
+		Outputs: make(map[string]string),
+	}
+}
+
+// loadManifest returns the manifest of the previous build. Without a
+// CacheDir only the previous build of this Builder is remembered.
+func (b *Builder) loadManifest() *manifest {
+	if b.site.CacheDir == "" {
+		return b.next
+	}
+
+	path := filepath.Join(b.site.CacheDir, manifestName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return newManifest()
+	}
+
+	m := newManifest()
+	if err := json.Unmarshal(data, m); err != nil || m.Version != cacheVersion {
+		log.Printf("Ignoring stale build cache: %s", path)
+		return newManifest()
+	}
+
+	return m
+}
+
+func (b *Builder) saveManifest() error {
+	if b.site.CacheDir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(b.next)
+	if err != nil {
+		return fmt.Errorf("failed to encode build cache: %w", err)
+	}
+
+	if err := os.MkdirAll(b.site.CacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	path := filepath.Join(b.site.CacheDir, manifestName)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write build cache: %w", err)
+	}
+
+	return nil
+}
+
+// cachedParse returns the page parsed from the same source by the previous
+// build. Metadata isn't cached, since JSON would change the types of its
+// values, and is decoded from data again.
+func (b *Builder) cachedParse(path, hash string, data []byte) (site.Page, bool) {
+	entry, ok := b.prev.Pages[path]
+	if !ok || entry.Hash != hash || !b.imagesUnchanged(path, entry.Images) {
+		return site.Page{}, false
+	}
+	metadata, err := parser.ReadMetadata(data)
+	if err != nil {
+		return site.Page{}, false
+	}
+	page := clonePage(entry.Page)
+	page.Metadata = metadata
+	return page, true
+}
+
+func (b *Builder) rememberPage(path, hash string, page site.Page) {
+	b.mu.Lock()
+	b.next.Pages[path] = cachedPage{Hash: hash, Page: clonePage(page), Images: b.imageDeps[path]}
+	b.mu.Unlock()
+}
+
+// sourceHash returns the content hash recorded for a page in this build,
+// covering the images it shows
+func (b *Builder) sourceHash(page *site.Page) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry := b.next.Pages[page.Path]
+	if len(entry.Images) == 0 {
+		return entry.Hash
+	}
+	files := make([]string, 0, len(entry.Images))
+	for file := range entry.Images {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	parts := []string{entry.Hash}
+	for _, file := range files {
+		parts = append(parts, file, entry.Images[file])
+	}
+	return hashStrings(parts...)
+}
+
+// writeOutput writes the result of render to path unless the previous
+// build already produced path from the same key. Files whose bytes are
+// unchanged are never rewritten, so their mtimes stay stable.
+func (b *Builder) writeOutput(path, key string, render func() ([]byte, error)) (bool, error) {
+	rel, err := filepath.Rel(b.site.OutputDir, path)
+	if err != nil {
+		return false, fmt.Errorf("failed to get relative path: %w", err)
+	}
+	rel = filepath.ToSlash(rel)
+
+	b.mu.Lock()
+	b.next.Outputs[rel] = key
+	b.mu.Unlock()
+
+	if b.prev.Outputs[rel] == key {
+		if _, err := os.Stat(path); err == nil {
+			return false, nil
+		}
+	}
+
+	data, err := render()
+	if err != nil {
+		return false, err
+	}
+
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, data) {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return true, nil
+}
+
+// pruneOutputDir removes files in OutputDir that this build did not produce
+func (b *Builder) pruneOutputDir() error {
+	var dirs []string
+
+	err := filepath.Walk(b.site.OutputDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Preserve .git directory if it exists
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		if info.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.site.OutputDir, path)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := b.next.Outputs[filepath.ToSlash(rel)]; ok {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		log.Printf("Removed stale file: %s", path)
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Remove directories left empty, deepest first
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if dir == b.site.OutputDir {
+			continue
+		}
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+			os.Remove(dir)
+		}
+	}
+
+	return nil
+}
+
+// buildKey hashes everything that affects every output: templates, site
+// config, the cache format and the version of the parser and the pages it
+// produces.
+func (b *Builder) buildKey() (string, error) {
+	templates, err := hashDir(b.site.TemplateDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash templates: %w", err)
+	}
+
+	config, err := yaml.Marshal(b.site.Config)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash config: %w", err)
+	}
+
+	return hashStrings(
+		fmt.Sprint(cacheVersion),
+		fmt.Sprint(parser.Version),
+		pageFormat,
+		templates,
+		string(config),
+		b.site.Environment,
+		b.site.SiteName,
+		b.site.BaseURL,
+	), nil
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hashStrings(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashDir hashes the names and contents of every file under dir
+func hashDir(dir string) (string, error) {
+	h := sha256.New()
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		h.Write([]byte(filepath.ToSlash(rel)))
+		h.Write([]byte{0})
+		h.Write([]byte(hashBytes(data)))
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// clonePage copies a page so cached entries never share maps or slices
+// with pages that are later modified during the build. Metadata is left
+// out; see cachedParse.
+func clonePage(p site.Page) site.Page {
+	p.Tags = append([]string(nil), p.Tags...)
+	p.Categories = append([]string(nil), p.Categories...)
+	p.Pages = nil
+	p.Metadata = nil
+	return p
+}
@@ -0,0 +1,97 @@
+package builder
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sporollan/site/internal/card"
+	"github.com/sporollan/site/internal/site"
+	"gopkg.in/yaml.v2"
+)
+
+// cardName is the file a page's preview image is written to, next to its
+// index.html
+const cardName = "og.png"
+
+// generateCards draws a preview image for every dated page without an
+// image of its own and points the page's Image at it. Cards are only
+// redrawn when what they show or the card settings change.
+func (b *Builder) generateCards() error {
+	cfg := b.site.Config.Cards
+	if !cfg.Enabled {
+		return nil
+	}
+
+	styleKey, err := cardStyleKey(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Fonts and the logo are only loaded once a card needs drawing
+	var style *card.Style
+	for _, page := range b.site.Pages {
+		if page.Image != "" || page.Date.IsZero() || page.Permalink == "/" {
+			continue
+		}
+
+		c := card.Card{
+			SiteName: b.site.SiteName,
+			Title:    page.Title,
+			Date:     page.Date,
+			Tags:     page.Tags,
+		}
+		key := hashStrings(styleKey, c.SiteName, c.Title, c.Date.Format(time.RFC3339), strings.Join(c.Tags, "\x00"))
+		path := filepath.Join(b.site.OutputDir, filepath.FromSlash(page.Permalink), cardName)
+
+		written, err := b.writeOutput(path, key, func() ([]byte, error) {
+			if style == nil {
+				loaded, err := card.LoadStyle(cfg)
+				if err != nil {
+					return nil, err
+				}
+				style = loaded
+			}
+			data, err := style.Draw(c)
+			if err != nil {
+				return nil, fmt.Errorf("failed to draw card for %s: %w", page.Path, err)
+			}
+			return data, nil
+		})
+		if err != nil {
+			return err
+		}
+		if written {
+			log.Printf("Generated: %s", path)
+		}
+
+		page.Image = page.Permalink + cardName
+	}
+
+	return nil
+}
+
+// cardStyleKey identifies the card settings, including the contents of
+// the font and logo files
+func cardStyleKey(cfg site.CardsConfig) (string, error) {
+	settings, err := yaml.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash card settings: %w", err)
+	}
+
+	parts := []string{string(settings)}
+	for _, file := range []string{cfg.Font, cfg.Logo} {
+		if file == "" {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		parts = append(parts, hashBytes(data))
+	}
+	return hashStrings(parts...), nil
+}
@@ -0,0 +1,177 @@
+package builder
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sporollan/site/internal/feed"
+	"github.com/sporollan/site/internal/site"
+)
+
+var feedFormats = []struct {
+	file   string
+	mime   string
+	name   string
+	render func(feed.Feed) ([]byte, error)
+}{
+	{feed.RSSFile, "application/rss+xml", "RSS", feed.RSS},
+	{feed.AtomFile, "application/atom+xml", "Atom", feed.Atom},
+	{feed.JSONFile, "application/feed+json", "JSON Feed", feed.JSON},
+}
+
+// feedLinks lists the site-wide and section feeds for autodiscovery
+func (b *Builder) feedLinks() []site.FeedLink {
+	var links []site.FeedLink
+
+	add := func(dir, title string) {
+		for _, format := range feedFormats {
+			links = append(links, site.FeedLink{
+				Title: fmt.Sprintf("%s (%s)", title, format.name),
+				Type:  format.mime,
+				URL:   b.site.BaseURL + dir + format.file,
+			})
+		}
+	}
+
+	add("/", b.site.SiteName)
+	for _, section := range b.site.Config.Feeds.Sections {
+		add("/"+section+"/", b.sectionFeedTitle(section))
+	}
+
+	return links
+}
+
+func (b *Builder) sectionFeedTitle(section string) string {
+	return fmt.Sprintf("%s - %s", b.site.SiteName, strings.Title(section))
+}
+
+// generateFeeds writes a site-wide feed, one feed per configured section
+// and, if enabled, one per tag.
+func (b *Builder) generateFeeds() error {
+	cfg := b.site.Config.Feeds
+
+	// Only dated pages belong in feeds
+	var dated []*site.Page
+	for _, page := range b.site.Pages {
+		if !page.Date.IsZero() {
+			dated = append(dated, page)
+		}
+	}
+	sortByDate(dated)
+
+	if err := b.writeFeeds("/", b.site.SiteName, dated); err != nil {
+		return err
+	}
+
+	for _, section := range cfg.Sections {
+		pages := append([]*site.Page(nil), b.site.Collections[section]...)
+		if len(pages) == 0 {
+			continue
+		}
+		sortByDate(pages)
+
+		if err := b.writeFeeds("/"+section+"/", b.sectionFeedTitle(section), pages); err != nil {
+			return err
+		}
+	}
+
+	if !cfg.Tags {
+		return nil
+	}
+
+	// Feeds per tag live next to the tag's list page
+	tags, ok := b.site.Taxonomies["tags"]
+	if !ok {
+		return nil
+	}
+
+	for _, term := range tags.Terms {
+		var pages []*site.Page
+		for _, page := range term.Pages {
+			if !page.Date.IsZero() {
+				pages = append(pages, page)
+			}
+		}
+		if len(pages) == 0 {
+			continue
+		}
+
+		title := fmt.Sprintf("%s - %s", b.site.SiteName, term.Name)
+		if err := b.writeFeeds(term.Permalink, title, pages); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFeeds writes every feed format for pages into the directory at dir,
+// a permalink such as "/blog/". pages must be sorted newest first.
+func (b *Builder) writeFeeds(dir, title string, pages []*site.Page) error {
+	cfg := b.site.Config.Feeds
+	if cfg.Limit > 0 && len(pages) > cfg.Limit {
+		pages = pages[:cfg.Limit]
+	}
+
+	f := feed.Feed{
+		Title:       title,
+		Description: fmt.Sprintf("Recent content on %s", title),
+		Link:        b.site.BaseURL + dir,
+		Dir:         b.site.BaseURL + dir,
+		Author:      b.site.Author,
+		Language:    "en",
+	}
+
+	for _, page := range pages {
+		item := feed.Item{
+			Title:       page.Title,
+			Link:        b.site.BaseURL + page.Permalink,
+			Published:   page.Date,
+			Updated:     page.Lastmod,
+			Summary:     page.Summary,
+			SummaryHTML: page.SummaryHTML,
+			Tags:        page.Tags,
+		}
+		if cfg.FullContent {
+			item.Content = page.Body
+		}
+		f.Items = append(f.Items, item)
+	}
+
+	key := hashStrings(b.key, "feed", dir, title, b.refsKey, b.membersKey(pages))
+	for _, format := range feedFormats {
+		format := format
+		path := filepath.Join(b.site.OutputDir, filepath.FromSlash(dir), format.file)
+
+		written, err := b.writeOutput(path, key, func() ([]byte, error) {
+			data, err := format.render(f)
+			if err != nil {
+				return nil, fmt.Errorf("failed to render feed %s: %w", path, err)
+			}
+			return data, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if written {
+			log.Printf("Generated feed with %d entries: %s", len(f.Items), path)
+		}
+	}
+
+	return nil
+}
+
+// sortByDate orders pages newest first, falling back to path for pages
+// with the same date so the order is stable.
+func sortByDate(pages []*site.Page) {
+	sort.Slice(pages, func(i, j int) bool {
+		if !pages[i].Date.Equal(pages[j].Date) {
+			return pages[i].Date.After(pages[j].Date)
+		}
+		return pages[i].Path < pages[j].Path
+	})
+}
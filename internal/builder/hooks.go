@@ -0,0 +1,118 @@
+package builder
+
+import (
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sporollan/site/internal/parser"
+	"github.com/sporollan/site/internal/site"
+)
+
+// renderHooks runs the templates in templates/_hooks/ for the parser,
+// adding what only the builder knows to their context. Pages are still
+// being parsed, so hooks shouldn't rely on Site.Pages.
+type renderHooks struct {
+	b *Builder
+}
+
+func (h renderHooks) HasHook(kind string) bool {
+	// Images get srcset attributes even without a hook
+	if kind == parser.HookImage && h.b.site.Config.Images.Enabled {
+		return true
+	}
+	return h.b.renderer.HasHook(kind)
+}
+
+func (h renderHooks) RenderHook(kind string, ctx *site.HookContext) (string, error) {
+	ctx.Site = h.b.site
+	if ctx.Destination != "" {
+		ctx.External = h.b.isExternal(ctx.Destination)
+	}
+
+	processed := false
+	if kind == parser.HookImage && !ctx.External && h.b.site.Config.Images.Enabled {
+		var err error
+		if processed, err = h.b.responsive(ctx); err != nil {
+			return "", err
+		}
+	}
+	if ctx.Destination != "" && !ctx.External && !processed {
+		ctx.Width, ctx.Height = h.b.imageSize(ctx.Path, ctx.Destination)
+	}
+
+	if kind == parser.HookImage && !h.b.renderer.HasHook(kind) {
+		return imageHTML(ctx), nil
+	}
+	return h.b.renderer.RenderHook(kind, ctx)
+}
+
+// imageHTML is what images are written as without an image hook
+func imageHTML(ctx *site.HookContext) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<img src="%s" alt="%s"`, html.EscapeString(ctx.Destination), html.EscapeString(ctx.PlainText))
+	if ctx.Title != "" {
+		fmt.Fprintf(&b, ` title="%s"`, html.EscapeString(ctx.Title))
+	}
+	if ctx.Srcset != "" {
+		fmt.Fprintf(&b, ` srcset="%s" sizes="%s"`, html.EscapeString(ctx.Srcset), html.EscapeString(ctx.Sizes))
+	}
+	if ctx.Width > 0 && ctx.Height > 0 {
+		fmt.Fprintf(&b, ` width="%d" height="%d"`, ctx.Width, ctx.Height)
+	}
+	b.WriteString(` loading="lazy" decoding="async">`)
+	return b.String()
+}
+
+// isExternal reports whether rawURL points to another site
+func (b *Builder) isExternal(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	base, err := url.Parse(b.site.BaseURL)
+	return err != nil || !strings.EqualFold(u.Host, base.Host)
+}
+
+// imageSize returns the dimensions of a local image, or 0 by 0 for
+// anything that isn't one
+func (b *Builder) imageSize(source, dest string) (int, int) {
+	path := b.localFile(source, dest)
+	if path == "" {
+		return 0, 0
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0
+	}
+	return cfg.Width, cfg.Height
+}
+
+// localFile returns the file a local URL in source refers to: absolute
+// paths are looked up in the static directory and relative ones next to
+// source. It is empty for other URLs.
+func (b *Builder) localFile(source, dest string) string {
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return ""
+	}
+
+	if strings.HasPrefix(u.Path, "/") {
+		return filepath.Join(b.site.StaticDir, filepath.FromSlash(u.Path))
+	}
+	return filepath.Join(filepath.Dir(source), filepath.FromSlash(u.Path))
+}
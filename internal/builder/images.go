@@ -0,0 +1,184 @@
+package builder
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sporollan/site/internal/imaging"
+	"github.com/sporollan/site/internal/site"
+)
+
+// imageExts are the extensions of the images resized for srcset; others,
+// such as SVGs, are linked as they are
+var imageExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+// responsiveImage is the set of copies made of a local image
+type responsiveImage struct {
+	key    string // source contents and settings the copies were made from
+	url    string // the copy at full size
+	srcset string
+	width  int // intrinsic size, upright
+	height int
+}
+
+// imageJob processes an image once however many pages show it
+type imageJob struct {
+	once sync.Once
+	img  *responsiveImage
+	err  error
+}
+
+// responsive points an image hook at resized copies of a local JPEG, PNG
+// or GIF, made on first use. It reports false for other images, which are
+// left alone.
+func (b *Builder) responsive(ctx *site.HookContext) (bool, error) {
+	file := b.localFile(ctx.Path, ctx.Destination)
+	if file == "" || !imageExts[strings.ToLower(filepath.Ext(file))] {
+		return false, nil
+	}
+	if info, err := os.Stat(file); err != nil || info.IsDir() {
+		return false, nil
+	}
+
+	img, err := b.processImage(file)
+	if err != nil {
+		return false, err
+	}
+	b.mu.Lock()
+	b.addImageDep(ctx.Path, file, img.key)
+	b.mu.Unlock()
+
+	ctx.Destination = img.url
+	ctx.Srcset = img.srcset
+	ctx.Sizes = b.site.Config.Images.Sizes
+	ctx.Width, ctx.Height = img.width, img.height
+	return true, nil
+}
+
+// processImage returns the copies of file, making them the first time it
+// is asked for in a build
+func (b *Builder) processImage(file string) (*responsiveImage, error) {
+	b.mu.Lock()
+	job, ok := b.images[file]
+	if !ok {
+		job = &imageJob{}
+		b.images[file] = job
+	}
+	b.mu.Unlock()
+
+	job.once.Do(func() {
+		job.img, job.err = b.makeImage(file)
+	})
+	return job.img, job.err
+}
+
+// makeImage writes a copy of file at each configured width narrower than
+// it and one at its own size, named after the file with a hash of its
+// contents and the settings, as in blog/photo.1f2e3d4c-480w.jpg. Copies
+// the previous build made from the same key are kept without decoding
+// the image.
+func (b *Builder) makeImage(file string) (*responsiveImage, error) {
+	cfg := b.site.Config.Images
+	if cfg.Quality < 1 || cfg.Quality > 100 {
+		return nil, fmt.Errorf("images.quality must be between 1 and 100, not %d", cfg.Quality)
+	}
+	for _, w := range cfg.Widths {
+		if w <= 0 {
+			return nil, fmt.Errorf("images.widths: %d is not a width", w)
+		}
+	}
+
+	rel := b.imageOutput(file)
+	if rel == "" {
+		return nil, fmt.Errorf("image %s is outside the content and static directories", file)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image %s: %w", file, err)
+	}
+	key := hashStrings(fmt.Sprint(cfg.Widths), fmt.Sprint(cfg.Quality), hashBytes(data))
+
+	img, err := imaging.Inspect(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	ext := path.Ext(rel)
+	base := strings.TrimSuffix(rel, ext) + "." + key[:8]
+	result := &responsiveImage{key: key, width: img.Width, height: img.Height}
+	var srcset []string
+	for _, w := range img.Widths(cfg.Widths) {
+		name := fmt.Sprintf("%s-%dw%s", base, w, ext)
+		out := filepath.Join(b.site.OutputDir, filepath.FromSlash(name))
+		written, err := b.writeOutput(out, key, func() ([]byte, error) {
+			return img.Resize(w, cfg.Quality)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to resize %s: %w", file, err)
+		}
+		if written {
+			log.Printf("Generated: %s", out)
+		}
+
+		u := (&url.URL{Path: "/" + name}).EscapedPath()
+		srcset = append(srcset, fmt.Sprintf("%s %dw", u, w))
+		result.url = u
+	}
+	result.srcset = strings.Join(srcset, ", ")
+
+	return result, nil
+}
+
+// imageOutput returns where the copies of file go in the output
+// directory, mirroring its place under the static or content directory
+func (b *Builder) imageOutput(file string) string {
+	for _, root := range []string{b.site.StaticDir, b.site.InputDir} {
+		rel, err := filepath.Rel(root, file)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return ""
+}
+
+// addImageDep records that the content file source shows file, so its
+// cached parse is only reused while the image is unchanged. b.mu must be
+// held.
+func (b *Builder) addImageDep(source, file, key string) {
+	deps, ok := b.imageDeps[source]
+	if !ok {
+		deps = make(map[string]string)
+		b.imageDeps[source] = deps
+	}
+	deps[file] = key
+}
+
+// imagesUnchanged reports whether the images a cached page showed would
+// still be processed into the same copies, making sure they exist
+func (b *Builder) imagesUnchanged(source string, deps map[string]string) bool {
+	for file, key := range deps {
+		img, err := b.processImage(file)
+		if err != nil || img.key != key {
+			return false
+		}
+	}
+
+	b.mu.Lock()
+	for file, key := range deps {
+		b.addImageDep(source, file, key)
+	}
+	b.mu.Unlock()
+	return true
+}
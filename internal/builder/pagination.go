@@ -0,0 +1,74 @@
+package builder
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/sporollan/site/internal/site"
+)
+
+// paginate splits pages into pagers of size pages each. The first pager
+// lives at base, the others at base/page/N/. A size of 0 or less yields a
+// single pager holding every page.
+func (b *Builder) paginate(base string, pages []*site.Page, size int) []*site.Pager {
+	if size <= 0 || len(pages) <= size {
+		size = max(len(pages), 1)
+	}
+	total := max((len(pages)+size-1)/size, 1)
+
+	url := func(n int) string {
+		if n == 1 {
+			return base
+		}
+		return fmt.Sprintf("%s%s/%d/", base, b.site.Config.Pagination.Path, n)
+	}
+
+	pagers := make([]*site.Pager, total)
+	for i := range pagers {
+		n := i + 1
+		pager := &site.Pager{
+			Number:     n,
+			TotalPages: total,
+			TotalItems: len(pages),
+			Pages:      pages[min(i*size, len(pages)):min(n*size, len(pages))],
+			URL:        url(n),
+			First:      url(1),
+			Last:       url(total),
+		}
+		if n > 1 {
+			pager.Prev = url(n - 1)
+		}
+		if n < total {
+			pager.Next = url(n + 1)
+		}
+		for j := 1; j <= total; j++ {
+			pager.Numbers = append(pager.Numbers, site.PagerLink{Number: j, URL: url(j), Current: j == n})
+		}
+		pagers[i] = pager
+	}
+
+	return pagers
+}
+
+// writePaginated calls write with a copy of list for every pager over
+// pages, its Pages narrowed to the pager's. key identifies the whole list;
+// each copy's key adds its page number. base/page/1/ redirects to base.
+func (b *Builder) writePaginated(list *site.Page, pages []*site.Page, size int, key string, write func(*site.Page, string) error) error {
+	pagers := b.paginate(list.Permalink, pages, size)
+
+	for _, pager := range pagers {
+		page := *list
+		page.Permalink = pager.URL
+		page.Pages = pager.Pages
+		page.Paginator = pager
+
+		if err := write(&page, hashStrings(key, strconv.Itoa(pager.Number))); err != nil {
+			return err
+		}
+	}
+
+	if len(pagers) > 1 {
+		return b.writeAlias(fmt.Sprintf("%s%s/1/", list.Permalink, b.site.Config.Pagination.Path), list.Permalink)
+	}
+	return nil
+}
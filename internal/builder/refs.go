@@ -0,0 +1,133 @@
+package builder
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sporollan/site/internal/parser"
+	"github.com/sporollan/site/internal/site"
+)
+
+// indexSources fills Site.Sources with every published page and section
+// index, so links and the ref template functions can find them. refsKey
+// changes whenever one of them moves or gains or loses a heading.
+func (b *Builder) indexSources() error {
+	sources := make(map[string]*site.Page)
+	add := func(page *site.Page) error {
+		rel, err := filepath.Rel(b.site.InputDir, page.Path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		sources[filepath.ToSlash(rel)] = page
+		return nil
+	}
+
+	for _, page := range b.site.Pages {
+		if err := add(page); err != nil {
+			return err
+		}
+	}
+	for name, index := range b.indexes {
+		sec := b.site.Sections[name]
+		index.Permalink = sec.Permalink
+		index.Section = name
+		index.Site = b.site
+		if err := add(index); err != nil {
+			return err
+		}
+	}
+
+	rels := make([]string, 0, len(sources))
+	for rel := range sources {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	keyParts := []string{}
+	for _, rel := range rels {
+		page := sources[rel]
+		keyParts = append(keyParts, rel, page.Permalink, strings.Join(page.Anchors, " "))
+	}
+
+	b.site.Sources = sources
+	b.refsKey = hashStrings(keyParts...)
+	return nil
+}
+
+// resolveRefs points the links to markdown files in pages and section
+// bodies at the permalinks of their targets. Links to missing files or
+// headings fail the build, or with markup.brokenRefs "warning" are logged
+// and left as written.
+func (b *Builder) resolveRefs() error {
+	mode := b.site.Config.Markup.BrokenRefs
+	if mode != "error" && mode != "warning" {
+		return fmt.Errorf("unknown markup.brokenRefs %q (want error or warning)", mode)
+	}
+
+	var errs []error
+	seen := make(map[string]bool)
+	resolve := func(page *site.Page, html string) string {
+		return parser.ExpandRefs(html, func(target string) string {
+			// Links in markdown are relative to the file, like on a forge
+			ref := target
+			if !strings.HasPrefix(ref, "/") && !strings.HasPrefix(ref, "../") {
+				ref = "./" + strings.TrimPrefix(ref, "./")
+			}
+
+			link, err := b.site.Ref(page.Path, ref)
+			if err == nil {
+				return link
+			}
+			err = &parser.Error{Path: page.Path, Line: refLine(page, target), Msg: fmt.Sprintf("broken link %s: %v", target, err)}
+			if !seen[err.Error()] {
+				seen[err.Error()] = true
+				errs = append(errs, err)
+			}
+			return target
+		})
+	}
+
+	for _, page := range b.site.Pages {
+		page.Body = resolve(page, page.Body)
+		page.SummaryHTML = resolve(page, page.SummaryHTML)
+	}
+
+	names := make([]string, 0, len(b.indexes))
+	for name := range b.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		index := b.indexes[name]
+		index.Body = resolve(index, index.Body)
+		b.site.Sections[name].Body = index.Body
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	if mode == "warning" {
+		for _, err := range errs {
+			log.Printf("Warning: %v", err)
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// refLine finds the line of the source file linking to target, or the
+// first line of the body when it isn't written out in the markdown
+func refLine(page *site.Page, target string) int {
+	i := strings.Index(page.RawBody, "("+target)
+	if i < 0 {
+		i = strings.Index(page.RawBody, target)
+	}
+	if i < 0 {
+		return page.BodyLine
+	}
+	return page.BodyLine + strings.Count(page.RawBody[:i], "\n")
+}
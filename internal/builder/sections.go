@@ -0,0 +1,230 @@
+package builder
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/sporollan/site/internal/site"
+)
+
+// sectionSorts are the orders a section's _index.md may ask for
+var sectionSorts = map[string]func(a, b *site.Page) bool{
+	"date": func(a, b *site.Page) bool {
+		if !a.Date.Equal(b.Date) {
+			return a.Date.After(b.Date)
+		}
+		return a.Path < b.Path
+	},
+	"weight": func(a, b *site.Page) bool {
+		if a.Weight != b.Weight {
+			return a.Weight < b.Weight
+		}
+		return a.Path < b.Path
+	},
+	"title": func(a, b *site.Page) bool {
+		if !strings.EqualFold(a.Title, b.Title) {
+			return strings.ToLower(a.Title) < strings.ToLower(b.Title)
+		}
+		return a.Path < b.Path
+	},
+}
+
+// buildSections creates a section for every content directory holding
+// pages or an _index.md, applies the _index.md settings and fills
+// Collections with each section's pages in its sort order. Pages without
+// an explicit template get their section's one.
+func (b *Builder) buildSections() error {
+	sections := make(map[string]*site.Section)
+
+	var get func(name string) *site.Section
+	get = func(name string) *site.Section {
+		if sec, ok := sections[name]; ok {
+			return sec
+		}
+
+		sec := &site.Section{
+			Name:         name,
+			Title:        b.site.SiteName,
+			Permalink:    "/",
+			Template:     "page.html",
+			ListTemplate: "list.html",
+			Sort:         "date",
+			PageSize:     b.site.Config.Pagination.PageSize,
+		}
+		if name != "" {
+			sec.Title = strings.Title(strings.ReplaceAll(path.Base(name), "-", " "))
+			sec.Permalink = "/" + name + "/"
+
+			parent := path.Dir(name)
+			if parent == "." {
+				parent = ""
+			}
+			sec.Parent = get(parent)
+			sec.Parent.Sections = append(sec.Parent.Sections, sec)
+		}
+
+		sections[name] = sec
+		return sec
+	}
+	get("")
+
+	// Apply _index.md settings in a stable order
+	names := make([]string, 0, len(b.indexes))
+	for name := range b.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	keyParts := []string{}
+	for _, name := range names {
+		index := b.indexes[name]
+		if err := applySectionIndex(get(name), index); err != nil {
+			return err
+		}
+		keyParts = append(keyParts, index.Path, b.sourceHash(index))
+	}
+	b.sectionKey = hashStrings(keyParts...)
+
+	for _, page := range b.site.Pages {
+		sec := get(page.Section)
+		sec.Pages = append(sec.Pages, page)
+
+		if _, explicit := page.Metadata["template"]; !explicit {
+			page.TemplateName = sec.Template
+		}
+	}
+
+	for name, sec := range sections {
+		less := sectionSorts[sec.Sort]
+		sort.SliceStable(sec.Pages, func(i, j int) bool {
+			return less(sec.Pages[i], sec.Pages[j])
+		})
+		sort.Slice(sec.Sections, func(i, j int) bool {
+			return sec.Sections[i].Name < sec.Sections[j].Name
+		})
+
+		if len(sec.Pages) > 0 {
+			b.site.Collections[collectionName(name)] = sec.Pages
+		}
+	}
+
+	for _, page := range b.site.Pages {
+		if page.Permalink == "/" {
+			page.Breadcrumbs = breadcrumbs(nil)
+			continue
+		}
+		page.Breadcrumbs = append(breadcrumbs(sections[page.Section]), site.Breadcrumb{
+			Title:     page.Title,
+			Permalink: page.Permalink,
+		})
+	}
+
+	b.site.Sections = sections
+	return nil
+}
+
+// applySectionIndex overrides the defaults of sec with the front matter and
+// body of its _index.md
+func applySectionIndex(sec *site.Section, index *site.Page) error {
+	// Page titles default to the file name, so only an explicit one counts
+	if title, ok := index.Metadata["title"].(string); ok && title != "" {
+		sec.Title = title
+	}
+	if description, ok := index.Metadata["description"].(string); ok {
+		sec.Description = description
+	}
+	sec.Body = index.Body
+
+	if template, ok := index.Metadata["template"].(string); ok && template != "" {
+		sec.Template = template
+	}
+	if template, ok := index.Metadata["listTemplate"].(string); ok && template != "" {
+		sec.ListTemplate = template
+	}
+	if order, ok := index.Metadata["sort"].(string); ok && order != "" {
+		if _, valid := sectionSorts[order]; !valid {
+			return fmt.Errorf("%s: unknown sort %q (want date, weight or title)", index.Path, order)
+		}
+		sec.Sort = order
+	}
+	if _, ok := index.Metadata["paginate"]; ok {
+		sec.PageSize = index.Paginate
+	}
+
+	return nil
+}
+
+// collectionName is the Collections key of a section. Pages at the root
+// of the content directory are collected under "pages".
+func collectionName(section string) string {
+	if section == "" {
+		return "pages"
+	}
+	return section
+}
+
+// breadcrumbs returns the trail from the home page down to sec
+func breadcrumbs(sec *site.Section) []site.Breadcrumb {
+	if sec == nil || sec.Parent == nil {
+		return []site.Breadcrumb{{Title: "Home", Permalink: "/"}}
+	}
+	return append(breadcrumbs(sec.Parent), site.Breadcrumb{
+		Title:     sec.Title,
+		Permalink: sec.Permalink,
+	})
+}
+
+// generateSectionPages writes a list page for every section below the
+// root, unless a content page such as blog/index.md already owns its URL.
+func (b *Builder) generateSectionPages() error {
+	owned := make(map[string]bool, len(b.site.Pages))
+	for _, page := range b.site.Pages {
+		owned[page.Permalink] = true
+	}
+
+	names := make([]string, 0, len(b.site.Sections))
+	for name := range b.site.Sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		sec := b.site.Sections[name]
+		if sec.Parent == nil || owned[sec.Permalink] {
+			continue
+		}
+
+		listPage := &site.Page{
+			Title:        sec.Title,
+			Description:  sec.Description,
+			Body:         sec.Body,
+			TemplateName: sec.ListTemplate,
+			SiteName:     b.site.SiteName,
+			BaseURL:      b.site.BaseURL,
+			Site:         b.site,
+			Permalink:    sec.Permalink,
+			Section:      sec.Name,
+			Breadcrumbs:  breadcrumbs(sec),
+			Subsections:  sec.Sections,
+		}
+		for _, page := range sec.Pages {
+			if page.Date.After(listPage.Date) {
+				listPage.Date = page.Date
+			}
+		}
+
+		// Subsection lists show titles and page counts
+		keyParts := []string{b.key, sec.Permalink, b.sectionKey, b.refsKey, b.membersKey(sec.Pages), b.shortcodesKey(b.indexes[name])}
+		for _, sub := range sec.Sections {
+			keyParts = append(keyParts, sub.Name, b.membersKey(sub.Pages))
+		}
+
+		if err := b.writePaginated(listPage, sec.Pages, sec.PageSize, hashStrings(keyParts...), b.writeListPage); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package builder
+
+import (
+	"sort"
+
+	"github.com/sporollan/site/internal/parser"
+	"github.com/sporollan/site/internal/site"
+)
+
+// runShortcodes runs the shortcodes of every page and section index now
+// that all pages and sections are known
+func (b *Builder) runShortcodes() error {
+	// Shortcodes get the site, so their output may change with any page
+	rels := make([]string, 0, len(b.site.Sources))
+	for rel := range b.site.Sources {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	keyParts := make([]string, 0, 2*len(rels))
+	for _, rel := range rels {
+		page := b.site.Sources[rel]
+		keyParts = append(keyParts, page.Path, b.sourceHash(page))
+	}
+	b.pagesKey = hashStrings(keyParts...)
+
+	for _, page := range b.site.Pages {
+		if err := b.expandShortcodes(page); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(b.indexes))
+	for name := range b.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		index, sec := b.indexes[name], b.site.Sections[name]
+		if err := b.expandShortcodes(index); err != nil {
+			return err
+		}
+		sec.Body = index.Body
+	}
+
+	return nil
+}
+
+// shortcodesKey identifies what the shortcodes of page may read: every
+// page of the site when it has any, nothing otherwise
+func (b *Builder) shortcodesKey(page *site.Page) string {
+	if page == nil || len(page.Shortcodes) == 0 {
+		return ""
+	}
+	return b.pagesKey
+}
+
+// expandShortcodes replaces the shortcode placeholders of a page with the
+// output of their templates. Errors point at the shortcode's line.
+func (b *Builder) expandShortcodes(page *site.Page) error {
+	if len(page.Shortcodes) == 0 {
+		return nil
+	}
+
+	outputs := make(map[int]string)
+	var expand func(html string) (string, error)
+	run := func(n int) (string, error) {
+		if output, ok := outputs[n]; ok {
+			return output, nil
+		}
+		sc := &page.Shortcodes[n]
+		inner, err := expand(sc.InnerHTML)
+		if err != nil {
+			return "", err
+		}
+		output, err := b.renderer.RenderShortcode(sc, inner, page)
+		if err != nil {
+			return "", &parser.Error{Path: page.Path, Line: sc.Line, Msg: err.Error()}
+		}
+
+		outputs[n] = output
+		return output, nil
+	}
+	expand = func(html string) (string, error) {
+		return parser.ExpandShortcodes(html, page.Shortcodes, run)
+	}
+
+	var err error
+	if page.Body, err = expand(page.Body); err != nil {
+		return err
+	}
+
+	// A summary cut at <!--more--> was taken from the placeholders
+	if parser.HasShortcodes(page.Summary, page.Shortcodes) {
+		if page.SummaryHTML, err = expand(page.SummaryHTML); err != nil {
+			return err
+		}
+		page.Summary, _, _ = parser.Summarize(page.SummaryHTML, 0)
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sporollan/site/internal/site"
+)
+
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	NS      string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	NS       string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// generateSitemap writes sitemap.xml for every published page and list
+// page. Sites with more than Sitemap.MaxURLs URLs get a sitemap index
+// pointing at sitemap1.xml, sitemap2.xml, ...
+func (b *Builder) generateSitemap() error {
+	var urls []sitemapURL
+
+	pages := append(append([]*site.Page(nil), b.site.Pages...), b.lists...)
+	for _, page := range pages {
+		if page.NoSitemap {
+			continue
+		}
+
+		url := sitemapURL{Loc: b.site.BaseURL + page.Permalink}
+		if !page.Lastmod.IsZero() {
+			url.LastMod = page.Lastmod.Format("2006-01-02")
+		} else if !page.Date.IsZero() {
+			url.LastMod = page.Date.Format("2006-01-02")
+		}
+		urls = append(urls, url)
+	}
+
+	sort.Slice(urls, func(i, j int) bool {
+		return urls[i].Loc < urls[j].Loc
+	})
+
+	maxURLs := b.site.Config.Sitemap.MaxURLs
+	if maxURLs <= 0 || len(urls) <= maxURLs {
+		return b.writeXML("sitemap.xml", sitemapURLSet{NS: sitemapNS, URLs: urls})
+	}
+
+	index := sitemapIndex{NS: sitemapNS}
+	for i := 0; i*maxURLs < len(urls); i++ {
+		chunk := urls[i*maxURLs : min((i+1)*maxURLs, len(urls))]
+		name := fmt.Sprintf("sitemap%d.xml", i+1)
+
+		if err := b.writeXML(name, sitemapURLSet{NS: sitemapNS, URLs: chunk}); err != nil {
+			return err
+		}
+
+		// The newest page in the chunk dates the whole file
+		var lastMod string
+		for _, url := range chunk {
+			if url.LastMod > lastMod {
+				lastMod = url.LastMod
+			}
+		}
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{
+			Loc:     b.site.BaseURL + "/" + name,
+			LastMod: lastMod,
+		})
+	}
+
+	return b.writeXML("sitemap.xml", index)
+}
+
+// generateRobots writes robots.txt referencing the sitemap. Outside
+// production all crawling is disallowed unless configured otherwise. A
+// robots.txt in the static directory takes precedence.
+func (b *Builder) generateRobots() error {
+	if _, err := os.Stat(filepath.Join(b.site.StaticDir, "robots.txt")); err == nil {
+		return nil
+	}
+
+	cfg := b.site.Config.Robots
+
+	var buf strings.Builder
+	buf.WriteString("User-agent: *\n")
+	if cfg.DisallowNonProduction && !b.site.IsProduction() {
+		buf.WriteString("Disallow: /\n")
+	} else {
+		for _, path := range cfg.Disallow {
+			fmt.Fprintf(&buf, "Disallow: %s\n", path)
+		}
+		if len(cfg.Disallow) == 0 {
+			buf.WriteString("Allow: /\n")
+		}
+	}
+	fmt.Fprintf(&buf, "\nSitemap: %s/sitemap.xml\n", b.site.BaseURL)
+
+	return b.writeGenerated("robots.txt", []byte(buf.String()))
+}
+
+func (b *Builder) writeXML(name string, v interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	buf.WriteString("\n")
+
+	return b.writeGenerated(name, buf.Bytes())
+}
+
+// writeGenerated writes a small file that is cheaper to regenerate than
+// to track inputs for; its content hash is its key.
+func (b *Builder) writeGenerated(name string, data []byte) error {
+	path := filepath.Join(b.site.OutputDir, filepath.FromSlash(name))
+	written, err := b.writeOutput(path, hashBytes(data), func() ([]byte, error) {
+		return data, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if written {
+		log.Printf("Generated: %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package builder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sporollan/site/internal/site"
+)
+
+// termValues returns the terms a page declares for a taxonomy. Tags and
+// categories come from the parsed page, custom taxonomies from front matter.
+func termValues(page *site.Page, taxonomy string) []string {
+	switch taxonomy {
+	case "tags":
+		return page.Tags
+	case "categories":
+		return page.Categories
+	}
+
+	var values []string
+	switch v := page.Metadata[taxonomy].(type) {
+	case string:
+		values = append(values, v)
+	case []interface{}:
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				values = append(values, str)
+			}
+		}
+	}
+	return values
+}
+
+func taxonomyPermalink(name string) string {
+	return "/" + site.Slugify(name) + "/"
+}
+
+// assignTerms links a page to the term pages of every configured taxonomy
+func (b *Builder) assignTerms(page *site.Page) {
+	page.Terms = nil
+
+	for _, cfg := range b.site.Config.Taxonomies {
+		var links []site.TermLink
+		for _, value := range termValues(page, cfg.Name) {
+			slug := site.Slugify(value)
+			if slug == "" {
+				continue
+			}
+			links = append(links, site.TermLink{
+				Name:      value,
+				Permalink: taxonomyPermalink(cfg.Name) + slug + "/",
+			})
+		}
+
+		if len(links) > 0 {
+			if page.Terms == nil {
+				page.Terms = make(map[string][]site.TermLink)
+			}
+			page.Terms[cfg.Name] = links
+		}
+	}
+}
+
+// generateTaxonomies groups published pages by term and writes an index
+// page per taxonomy plus a list page per term.
+func (b *Builder) generateTaxonomies() error {
+	b.site.Taxonomies = make(map[string]*site.Taxonomy)
+
+	for _, cfg := range b.site.Config.Taxonomies {
+		title := cfg.Title
+		if title == "" {
+			title = strings.Title(cfg.Name)
+		}
+
+		taxonomy := &site.Taxonomy{
+			Name:      cfg.Name,
+			Title:     title,
+			Permalink: taxonomyPermalink(cfg.Name),
+		}
+		b.site.Taxonomies[cfg.Name] = taxonomy
+
+		terms := make(map[string]*site.Term)
+		for _, page := range b.site.Pages {
+			for _, value := range termValues(page, cfg.Name) {
+				slug := site.Slugify(value)
+				if slug == "" {
+					continue
+				}
+
+				term, ok := terms[slug]
+				if !ok {
+					term = &site.Term{
+						Name:      value,
+						Slug:      slug,
+						Permalink: taxonomy.Permalink + slug + "/",
+					}
+					terms[slug] = term
+					taxonomy.Terms = append(taxonomy.Terms, term)
+				}
+
+				// A page listing the same term twice is counted once
+				if n := len(term.Pages); n == 0 || term.Pages[n-1] != page {
+					term.Pages = append(term.Pages, page)
+				}
+			}
+		}
+
+		if len(taxonomy.Terms) == 0 {
+			continue
+		}
+
+		sort.Slice(taxonomy.Terms, func(i, j int) bool {
+			return taxonomy.Terms[i].Slug < taxonomy.Terms[j].Slug
+		})
+
+		if err := b.writeTaxonomy(taxonomy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *Builder) writeTaxonomy(taxonomy *site.Taxonomy) error {
+	var members []string
+
+	for _, term := range taxonomy.Terms {
+		sortByDate(term.Pages)
+
+		termPage := &site.Page{
+			Title:        term.Name,
+			Description:  fmt.Sprintf("%s: %s", taxonomy.Title, term.Name),
+			TemplateName: "list.html",
+			SiteName:     b.site.SiteName,
+			BaseURL:      b.site.BaseURL,
+			Site:         b.site,
+			Permalink:    term.Permalink,
+			Date:         term.Pages[0].Date,
+			Term:         term,
+		}
+
+		key := hashStrings(b.key, term.Permalink, term.Name, b.refsKey, b.membersKey(term.Pages))
+		size := b.site.Config.Pagination.PageSize
+		if err := b.writePaginated(termPage, term.Pages, size, key, b.writeListPage); err != nil {
+			return err
+		}
+		members = append(members, term.Slug, key)
+	}
+
+	indexPage := &site.Page{
+		Title:        taxonomy.Title,
+		TemplateName: "terms.html",
+		SiteName:     b.site.SiteName,
+		BaseURL:      b.site.BaseURL,
+		Site:         b.site,
+		Permalink:    taxonomy.Permalink,
+		Taxonomy:     taxonomy,
+	}
+
+	key := hashStrings(append([]string{b.key, taxonomy.Permalink, taxonomy.Title}, members...)...)
+	return b.writeListPage(indexPage, key)
+}
@@ -0,0 +1,274 @@
+// Package card draws the preview images shown when a page is shared on
+// social sites.
+package card
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	// Logos may be any of these formats
+	_ "image/gif"
+	_ "image/jpeg"
+
+	"github.com/sporollan/site/internal/site"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Size of a card, the 1.91:1 Open Graph recommends
+const (
+	Width  = 1200
+	Height = 630
+)
+
+// Layout, in pixels
+const (
+	padding    = 80
+	accentBar  = 16
+	logoHeight = 88
+)
+
+// titleSizes are tried in order until the title fits in three lines; at
+// the smallest size it may take maxTitleLines
+var titleSizes = []float64{72, 62, 52}
+
+const maxTitleLines = 4
+
+// Card is what a card shows
+type Card struct {
+	SiteName string
+	Title    string
+	Date     time.Time
+	Tags     []string
+}
+
+// Style is the look of every card of a site
+type Style struct {
+	Background color.Color
+	Foreground color.Color // title
+	Accent     color.Color // site name, tags and the bar on the left
+	Muted      color.Color // date
+
+	regular *opentype.Font
+	bold    *opentype.Font
+	logo    image.Image
+}
+
+// LoadStyle reads the colors, font and logo of cfg. Without a font file
+// the Go fonts are used.
+func LoadStyle(cfg site.CardsConfig) (*Style, error) {
+	s := &Style{}
+	for _, c := range []struct {
+		name  string
+		value string
+		dst   *color.Color
+	}{
+		{"background", cfg.Background, &s.Background},
+		{"foreground", cfg.Foreground, &s.Foreground},
+		{"accent", cfg.Accent, &s.Accent},
+		{"muted", cfg.Muted, &s.Muted},
+	} {
+		parsed, err := parseColor(c.value)
+		if err != nil {
+			return nil, fmt.Errorf("cards.%s: %w", c.name, err)
+		}
+		*c.dst = parsed
+	}
+
+	var err error
+	if cfg.Font != "" {
+		data, err := os.ReadFile(cfg.Font)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read card font: %w", err)
+		}
+		if s.regular, err = opentype.Parse(data); err != nil {
+			return nil, fmt.Errorf("failed to parse card font %s: %w", cfg.Font, err)
+		}
+		s.bold = s.regular
+	} else {
+		if s.regular, err = opentype.Parse(goregular.TTF); err != nil {
+			return nil, err
+		}
+		if s.bold, err = opentype.Parse(gobold.TTF); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Logo != "" {
+		f, err := os.Open(cfg.Logo)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read card logo: %w", err)
+		}
+		defer f.Close()
+		if s.logo, _, err = image.Decode(f); err != nil {
+			return nil, fmt.Errorf("failed to decode card logo %s: %w", cfg.Logo, err)
+		}
+	}
+
+	return s, nil
+}
+
+// parseColor reads a #rgb or #rrggbb color
+func parseColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return nil, fmt.Errorf("invalid color %q (want #rrggbb)", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// Draw renders c as a PNG
+func (s *Style) Draw(c Card) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, Width, Height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(s.Background), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, accentBar, Height), image.NewUniform(s.Accent), image.Point{}, draw.Src)
+
+	left := padding + accentBar
+	textWidth := Width - left - padding
+
+	// Site name and logo along the top
+	if s.logo != nil {
+		b := s.logo.Bounds()
+		w := b.Dx() * logoHeight / max(b.Dy(), 1)
+		dst := image.Rect(Width-padding-w, padding, Width-padding, padding+logoHeight)
+		draw.CatmullRom.Scale(img, dst, s.logo, b, draw.Over, nil)
+		textWidth -= w + 24
+	}
+	nameFace, err := s.face(s.bold, 34)
+	if err != nil {
+		return nil, err
+	}
+	defer nameFace.Close()
+	drawText(img, nameFace, s.Accent, left, padding+34, fit(nameFace, c.SiteName, textWidth))
+
+	// Date and tags along the bottom
+	metaFace, err := s.face(s.regular, 30)
+	if err != nil {
+		return nil, err
+	}
+	defer metaFace.Close()
+	baseline := Height - padding
+	x := left
+	if !c.Date.IsZero() {
+		date := c.Date.Format("January 2, 2006")
+		drawText(img, metaFace, s.Muted, x, baseline, date)
+		x += font.MeasureString(metaFace, date).Ceil() + 40
+	}
+	if len(c.Tags) > 0 {
+		tags := "#" + strings.Join(c.Tags, "  #")
+		drawText(img, metaFace, s.Accent, x, baseline, fit(metaFace, tags, Width-padding-x))
+	}
+
+	// The title fills the middle, as large as fits
+	var titleFace font.Face
+	var lines []string
+	for i, size := range titleSizes {
+		if titleFace != nil {
+			titleFace.Close()
+		}
+		if titleFace, err = s.face(s.bold, size); err != nil {
+			return nil, err
+		}
+		lines = wrap(titleFace, c.Title, Width-left-padding)
+		if len(lines) <= 3 || i == len(titleSizes)-1 {
+			break
+		}
+	}
+	defer titleFace.Close()
+	if len(lines) > maxTitleLines {
+		lines = lines[:maxTitleLines]
+		lines[maxTitleLines-1] = fit(titleFace, lines[maxTitleLines-1]+"…", Width-left-padding)
+	}
+
+	lineHeight := titleFace.Metrics().Height.Ceil() * 6 / 5
+	top := padding + 34 + 60
+	bottom := baseline - 30 - 50
+	y := top + (bottom-top-lineHeight*len(lines))/2 + titleFace.Metrics().Ascent.Ceil()
+	for _, line := range lines {
+		drawText(img, titleFace, s.Foreground, left, y, line)
+		y += lineHeight
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode card: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Style) face(f *opentype.Font, size float64) (font.Face, error) {
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load card font: %w", err)
+	}
+	return face, nil
+}
+
+func drawText(img draw.Image, face font.Face, c color.Color, x, y int, text string) {
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(c), Face: face, Dot: fixed.P(x, y)}
+	d.DrawString(text)
+}
+
+// wrap breaks text into lines no wider than width, splitting words that
+// are too long on their own
+func wrap(face font.Face, text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if font.MeasureString(face, candidate).Ceil() <= width {
+			line = candidate
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		for font.MeasureString(face, word).Ceil() > width {
+			cut := fit(face, word, width)
+			cut = strings.TrimSuffix(cut, "…")
+			if cut == "" {
+				break
+			}
+			lines = append(lines, cut)
+			word = word[len(cut):]
+		}
+		line = word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// fit shortens text with an ellipsis until it is no wider than width
+func fit(face font.Face, text string, width int) string {
+	if font.MeasureString(face, text).Ceil() <= width {
+		return text
+	}
+	runes := []rune(strings.TrimSuffix(text, "…"))
+	for len(runes) > 0 {
+		runes = runes[:len(runes)-1]
+		if s := strings.TrimRight(string(runes), " ") + "…"; font.MeasureString(face, s).Ceil() <= width {
+			return s
+		}
+	}
+	return ""
+}
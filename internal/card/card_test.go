@@ -0,0 +1,156 @@
+package card
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sporollan/site/internal/site"
+	"golang.org/x/image/font"
+)
+
+func testStyle(t *testing.T, cfg site.CardsConfig) *Style {
+	t.Helper()
+
+	defaults := site.DefaultConfig().Cards
+	cfg.Background, cfg.Foreground, cfg.Accent, cfg.Muted = defaults.Background, defaults.Foreground, defaults.Accent, defaults.Muted
+	style, err := LoadStyle(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return style
+}
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    color.Color
+		wantErr bool
+	}{
+		{"#1a1b26", color.RGBA{0x1a, 0x1b, 0x26, 0xff}, false},
+		{"#fff", color.RGBA{0xff, 0xff, 0xff, 0xff}, false},
+		{"2ac3de", color.RGBA{0x2a, 0xc3, 0xde, 0xff}, false},
+		{"#12345", nil, true},
+		{"blue", nil, true},
+		{"", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseColor(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseColor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseColor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	style := testStyle(t, site.CardsConfig{})
+	face, err := style.face(style.bold, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer face.Close()
+
+	width := font.MeasureString(face, "Building a static").Ceil()
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Short", []string{"Short"}},
+		{"Building a static site generator in Go", []string{"Building a static", "site generator", "in Go"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := wrap(face, tt.text, width); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrap(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	// Words wider than a line are split
+	long := strings.Repeat("x", 60)
+	lines := wrap(face, long, width)
+	if len(lines) < 2 || strings.Join(lines, "") != long {
+		t.Errorf("wrap(long word) = %q", lines)
+	}
+	for _, line := range lines {
+		if font.MeasureString(face, line).Ceil() > width {
+			t.Errorf("line %q is wider than %d", line, width)
+		}
+	}
+}
+
+func TestDraw(t *testing.T) {
+	logoPath := filepath.Join(t.TempDir(), "logo.png")
+	logo := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for i := range logo.Pix {
+		logo.Pix[i] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, logo); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(logoPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	style := testStyle(t, site.CardsConfig{Logo: logoPath})
+	data, err := style.Draw(Card{
+		SiteName: "Test Site",
+		Title:    strings.Repeat("A very long title that keeps going ", 10),
+		Date:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Tags:     []string{"go", "web"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Size(); got != image.Pt(Width, Height) {
+		t.Fatalf("size = %v, want %dx%d", got, Width, Height)
+	}
+
+	for _, px := range []struct {
+		name string
+		at   image.Point
+		want color.Color
+	}{
+		{"accent bar", image.Pt(4, Height/2), style.Accent},
+		{"background", image.Pt(Width-10, Height-10), style.Background},
+		{"logo", image.Pt(Width-padding-10, padding+logoHeight/2), color.RGBA{0xff, 0xff, 0xff, 0xff}},
+	} {
+		r, g, b, _ := img.At(px.at.X, px.at.Y).RGBA()
+		wr, wg, wb, _ := px.want.RGBA()
+		if r != wr || g != wg || b != wb {
+			t.Errorf("%s pixel = %v, want %v", px.name, img.At(px.at.X, px.at.Y), px.want)
+		}
+	}
+}
+
+func TestLoadStyleErrors(t *testing.T) {
+	cfg := site.DefaultConfig().Cards
+	cfg.Accent = "teal"
+	if _, err := LoadStyle(cfg); err == nil || !strings.Contains(err.Error(), "cards.accent") {
+		t.Errorf("LoadStyle() error = %v, want invalid accent", err)
+	}
+
+	cfg = site.DefaultConfig().Cards
+	cfg.Font = filepath.Join(t.TempDir(), "missing.ttf")
+	if _, err := LoadStyle(cfg); err == nil {
+		t.Error("LoadStyle() succeeded with a missing font")
+	}
+}
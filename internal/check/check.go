@@ -0,0 +1,341 @@
+// Package check finds broken references in a generated site: links,
+// fragments, images, scripts and stylesheets that don't resolve.
+package check
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Problem is a reference in a generated page that doesn't resolve
+type Problem struct {
+	File string // HTML file, relative to the output directory
+	Line int
+	URL  string
+	Msg  string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s", p.File, p.Line, p.URL, p.Msg)
+}
+
+// WriteReport writes problems grouped by page, naming pages by their path
+// under dir, as
+//
+//	public/blog/post/index.html
+//	  12: https://example.com/gone: 404 Not Found
+func WriteReport(w io.Writer, dir string, problems []Problem) {
+	sorted := append([]Problem(nil), problems...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].File != sorted[j].File {
+			return sorted[i].File < sorted[j].File
+		}
+		return sorted[i].Line < sorted[j].Line
+	})
+
+	file := ""
+	for _, p := range sorted {
+		if p.File != file {
+			if file != "" {
+				fmt.Fprintln(w)
+			}
+			file = p.File
+			fmt.Fprintln(w, filepath.Join(dir, filepath.FromSlash(file)))
+		}
+		fmt.Fprintf(w, "  %d: %s: %s\n", p.Line, p.URL, p.Msg)
+	}
+}
+
+// Link is a URL referenced by an element of a page
+type Link struct {
+	URL  string
+	Line int
+}
+
+// page is a parsed HTML file
+type page struct {
+	file  string          // relative to the output directory, with slashes
+	ids   map[string]bool // element ids and anchor names, for fragments
+	links []Link
+}
+
+// linkAttrs are the attributes holding URLs, by element
+var linkAttrs = map[string][]string{
+	"a":      {"href"},
+	"area":   {"href"},
+	"link":   {"href"},
+	"img":    {"src", "srcset"},
+	"source": {"src", "srcset"},
+	"script": {"src"},
+	"iframe": {"src"},
+	"embed":  {"src"},
+	"audio":  {"src"},
+	"video":  {"src", "poster"},
+	"track":  {"src"},
+}
+
+// parsePage reads the ids and references of an HTML file. Each reference
+// gets the line its element starts on.
+func parsePage(file string, data []byte) *page {
+	p := &page{file: file, ids: make(map[string]bool)}
+	z := html.NewTokenizer(bytes.NewReader(data))
+	line := 1
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return p
+		}
+		start := line
+		line += bytes.Count(z.Raw(), []byte("\n"))
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		token := z.Token()
+		for _, attr := range token.Attr {
+			switch {
+			case attr.Key == "id" && attr.Val != "":
+				p.ids[attr.Val] = true
+			case attr.Key == "name" && token.Data == "a" && attr.Val != "":
+				p.ids[attr.Val] = true
+			}
+		}
+
+		// Hints such as preconnect name origins rather than resources
+		if token.Data == "link" && linkRel(token, "preconnect", "dns-prefetch") {
+			continue
+		}
+		for _, attr := range token.Attr {
+			if !contains(linkAttrs[token.Data], attr.Key) {
+				continue
+			}
+			urls := []string{attr.Val}
+			if attr.Key == "srcset" {
+				urls = srcsetURLs(attr.Val)
+			}
+			for _, u := range urls {
+				if u = strings.TrimSpace(u); u != "" {
+					p.links = append(p.links, Link{URL: u, Line: start})
+				}
+			}
+		}
+	}
+}
+
+// linkRel reports whether the rel attribute of a link element has one of
+// values
+func linkRel(token html.Token, values ...string) bool {
+	for _, attr := range token.Attr {
+		if attr.Key != "rel" {
+			continue
+		}
+		for _, rel := range strings.Fields(strings.ToLower(attr.Val)) {
+			if contains(values, rel) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// srcsetURLs returns the URLs of a srcset, as in "a.jpg 480w, b.jpg 800w"
+func srcsetURLs(srcset string) []string {
+	var urls []string
+	for _, candidate := range strings.Split(srcset, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 {
+			urls = append(urls, fields[0])
+		}
+	}
+	return urls
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Checker validates the references of the HTML files in a site's output
+// directory
+type Checker struct {
+	dir   string
+	base  *url.URL // absolute links to the base URL count as internal
+	pages map[string]*page
+}
+
+// New creates a checker for the output directory dir of the site at
+// baseURL
+func New(dir, baseURL string) *Checker {
+	base, err := url.Parse(baseURL)
+	if err != nil || base.Host == "" {
+		base = nil
+	}
+	return &Checker{dir: dir, base: base}
+}
+
+// load parses every HTML file in the output directory once
+func (c *Checker) load() error {
+	if c.pages != nil {
+		return nil
+	}
+
+	pages := make(map[string]*page)
+	err := filepath.WalkDir(c.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.EqualFold(filepath.Ext(p), ".html") {
+			return nil
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		}
+		rel, err := filepath.Rel(c.dir, p)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		rel = filepath.ToSlash(rel)
+		pages[rel] = parsePage(rel, data)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read output: %w", err)
+	}
+
+	c.pages = pages
+	return nil
+}
+
+// files returns the parsed HTML files in order
+func (c *Checker) files() []string {
+	files := make([]string, 0, len(c.pages))
+	for file := range c.pages {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// Internal checks that every link to the site itself, every #fragment
+// and every image, script and stylesheet resolves to a file in the output
+// directory. Problems are sorted by file and line.
+func (c *Checker) Internal() ([]Problem, error) {
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+	for _, file := range c.files() {
+		p := c.pages[file]
+		for _, link := range p.links {
+			if msg := c.checkInternal(p, link.URL); msg != "" {
+				problems = append(problems, Problem{File: file, Line: link.Line, URL: link.URL, Msg: msg})
+			}
+		}
+	}
+	return problems, nil
+}
+
+// checkInternal describes what is wrong with a reference from p, or
+// returns "" when it resolves or isn't internal
+func (c *Checker) checkInternal(p *page, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid URL"
+	}
+	if !c.isInternal(u) {
+		return ""
+	}
+
+	target := p.file
+	if u.Path != "" {
+		urlPath := u.Path
+		if u.Host != "" && c.base != nil {
+			urlPath = "/" + strings.TrimPrefix(strings.TrimPrefix(urlPath, strings.TrimSuffix(c.base.Path, "/")), "/")
+		}
+		if !strings.HasPrefix(urlPath, "/") {
+			urlPath = path.Join(path.Dir("/"+p.file), urlPath) + trailingSlash(urlPath)
+		}
+
+		var ok bool
+		if target, ok = c.lookup(urlPath); !ok {
+			return "not found in the output"
+		}
+	}
+
+	if u.Fragment == "" || u.Fragment == "top" {
+		return ""
+	}
+	targetPage, ok := c.pages[target]
+	if !ok {
+		return ""
+	}
+	if !targetPage.ids[u.Fragment] {
+		return fmt.Sprintf("no element with id %q in %s", u.Fragment, target)
+	}
+	return ""
+}
+
+// isInternal reports whether u points into the site, rather than at
+// another site or through a scheme such as mailto:
+func (c *Checker) isInternal(u *url.URL) bool {
+	switch u.Scheme {
+	case "":
+		if u.Host == "" {
+			return true
+		}
+	case "http", "https":
+	default:
+		return false
+	}
+	return c.base != nil && strings.EqualFold(u.Host, c.base.Host)
+}
+
+func trailingSlash(p string) string {
+	if strings.HasSuffix(p, "/") {
+		return "/"
+	}
+	return ""
+}
+
+// lookup finds the file a server would answer urlPath with: the file
+// itself, or the index.html of a directory
+func (c *Checker) lookup(urlPath string) (string, bool) {
+	rel := strings.TrimPrefix(path.Clean(urlPath), "/")
+	if rel == "" || rel == "." {
+		rel = "index.html"
+	}
+	if strings.HasSuffix(urlPath, "/") && rel != "index.html" {
+		rel = path.Join(rel, "index.html")
+	}
+
+	info, err := os.Stat(filepath.Join(c.dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", false
+	}
+	if !info.IsDir() {
+		return rel, true
+	}
+
+	index := path.Join(rel, "index.html")
+	if _, err := os.Stat(filepath.Join(c.dir, filepath.FromSlash(index))); err != nil {
+		return "", false
+	}
+	return index, true
+}
@@ -0,0 +1,230 @@
+package check
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	data := `<!DOCTYPE html>
+<html><head>
+<link rel="preconnect" href="https://fonts.example.com">
+<link rel="stylesheet" href="/css/style.css">
+</head><body>
+<h2 id="why">Why</h2><a name="old"></a>
+<img src="a.png"
+     srcset="a-480.png 480w, a-800.png 800w">
+<script>var s = "<a href='/not-a-link/'>";</script>
+<a href="">empty</a>
+</body></html>`
+
+	p := parsePage("index.html", []byte(data))
+
+	wantLinks := []Link{
+		{URL: "/css/style.css", Line: 4},
+		{URL: "a.png", Line: 7},
+		{URL: "a-480.png", Line: 7},
+		{URL: "a-800.png", Line: 7},
+	}
+	if !reflect.DeepEqual(p.links, wantLinks) {
+		t.Errorf("links = %v, want %v", p.links, wantLinks)
+	}
+	if want := map[string]bool{"why": true, "old": true}; !reflect.DeepEqual(p.ids, want) {
+		t.Errorf("ids = %v, want %v", p.ids, want)
+	}
+}
+
+func TestInternal(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"index.html": `<link rel="stylesheet" href="/css/style.css">
+<a href="/about/">About</a> <a href="/about/#why">Why</a> <a href="about/">Relative</a>
+<a href="/about">No slash</a> <a href="https://example.com/about/">Absolute</a>
+<a href="#top">Top</a> <a href="#main">Main</a> <main id="main"></main>
+<a href="https://other.example/missing/">Elsewhere</a> <a href="mailto:me@example.com">Mail</a>
+<a href="/contact/">Contact</a>
+<a href="/about/#how">How</a>
+<img src="/img/missing.png">
+<a href="#nowhere">Nowhere</a>
+<a href="https://example.com/gone/">Gone</a>`,
+		"about/index.html": `<h2 id="why">Why</h2>
+<a href="../index.html">Home</a> <a href="../css/style.css">CSS</a> <a href="../../x/">Above</a>`,
+		"css/style.css": "body {}",
+	})
+
+	problems, err := New(dir, "https://example.com").Internal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Problem{
+		{File: "about/index.html", Line: 2, URL: "../../x/", Msg: "not found in the output"},
+		{File: "index.html", Line: 6, URL: "/contact/", Msg: "not found in the output"},
+		{File: "index.html", Line: 7, URL: "/about/#how", Msg: `no element with id "how" in about/index.html`},
+		{File: "index.html", Line: 8, URL: "/img/missing.png", Msg: "not found in the output"},
+		{File: "index.html", Line: 9, URL: "#nowhere", Msg: `no element with id "nowhere" in index.html`},
+		{File: "index.html", Line: 10, URL: "https://example.com/gone/", Msg: "not found in the output"},
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("Internal() =\n%v\nwant\n%v", problems, want)
+	}
+
+	if got, want := problems[1].String(), "index.html:6: /contact/: not found in the output"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+// linkServer stands in for other sites. It counts requests by method and
+// path and tracks how many are in flight at once.
+type linkServer struct {
+	mu          sync.Mutex
+	requests    map[string]int
+	times       []time.Time
+	inFlight    int
+	maxInFlight int
+}
+
+func (s *linkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.Method+" "+r.URL.Path]++
+	count := s.requests[r.Method+" "+r.URL.Path]
+	s.times = append(s.times, time.Now())
+	s.inFlight++
+	s.maxInFlight = max(s.maxInFlight, s.inFlight)
+	s.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+
+	switch r.URL.Path {
+	case "/ok", "/ok/2", "/ok/3":
+	case "/no-head":
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case "/flaky":
+		if count == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestExternal(t *testing.T) {
+	ls := &linkServer{requests: make(map[string]int)}
+	srv := httptest.NewServer(ls)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"index.html": `<a href="` + srv.URL + `/ok#intro">OK</a> <a href="/local/">Local</a>
+<a href="` + srv.URL + `/gone">Gone</a>
+<a href="` + srv.URL + `/no-head">No HEAD</a> <a href="` + srv.URL + `/flaky">Flaky</a>`,
+		"blog/post/index.html": `<p>
+<a href="` + srv.URL + `/gone">Gone again</a> <a href="` + srv.URL + `/ok/2">OK</a> <a href="` + srv.URL + `/ok/3">OK</a>`,
+	})
+
+	opts := ExternalOptions{
+		Client:       srv.Client(),
+		Concurrency:  2,
+		HostInterval: -1,
+		RetryDelay:   time.Millisecond,
+		CacheFile:    filepath.Join(t.TempDir(), "links.json"),
+	}
+
+	problems, err := New(dir, "https://example.com").External(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Problem{
+		{File: "blog/post/index.html", Line: 2, URL: srv.URL + "/gone", Msg: "404 Not Found"},
+		{File: "index.html", Line: 2, URL: srv.URL + "/gone", Msg: "404 Not Found"},
+	}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("External() =\n%v\nwant\n%v", problems, want)
+	}
+
+	// HEAD falls back to GET on errors, and temporary errors are retried
+	wantRequests := map[string]int{
+		"HEAD /ok":      1,
+		"HEAD /ok/2":    1,
+		"HEAD /ok/3":    1,
+		"HEAD /no-head": 1,
+		"GET /no-head":  1,
+		"HEAD /flaky":   2,
+		"GET /flaky":    1,
+		"HEAD /gone":    1,
+		"GET /gone":     1,
+	}
+	if !reflect.DeepEqual(ls.requests, wantRequests) {
+		t.Errorf("requests = %v, want %v", ls.requests, wantRequests)
+	}
+	if ls.maxInFlight > 2 {
+		t.Errorf("%d requests in flight, want at most 2", ls.maxInFlight)
+	}
+
+	var report bytes.Buffer
+	WriteReport(&report, "public", append(problems, Problem{File: "blog/post/index.html", Line: 1, URL: "https://example.com/a/../b", Msg: "not found"}))
+	wantReport := filepath.Join("public", "blog", "post", "index.html") + "\n  1: https://example.com/a/../b: not found\n  2: " + srv.URL + "/gone: 404 Not Found\n\n" +
+		filepath.Join("public", "index.html") + "\n  2: " + srv.URL + "/gone: 404 Not Found\n"
+	if report.String() != wantReport {
+		t.Errorf("WriteReport() =\n%s\nwant\n%s", report.String(), wantReport)
+	}
+
+	t.Run("cache", func(t *testing.T) {
+		ls.requests = make(map[string]int)
+		if _, err := New(dir, "https://example.com").External(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := ls.requests["HEAD /ok"] + ls.requests["HEAD /flaky"]; got != 0 {
+			t.Errorf("working links requested %d times, want them cached", got)
+		}
+		if ls.requests["HEAD /gone"] != 1 {
+			t.Errorf("broken link requested %d times, want 1", ls.requests["HEAD /gone"])
+		}
+	})
+
+	t.Run("host interval", func(t *testing.T) {
+		ls.times = nil
+		opts := opts
+		opts.CacheFile = ""
+		opts.Concurrency = 4
+		opts.HostInterval = 30 * time.Millisecond
+		if _, err := New(dir, "https://example.com").External(context.Background(), opts); err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < len(ls.times); i++ {
+			if gap := ls.times[i].Sub(ls.times[i-1]); gap < 25*time.Millisecond {
+				t.Errorf("requests %d and %d were %v apart, want at least 30ms", i-1, i, gap)
+			}
+		}
+	})
+}
@@ -0,0 +1,350 @@
+package check
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ExternalOptions tune how links to other sites are checked. Zero values
+// pick the defaults; a negative HostInterval or Retries turns spacing or
+// retrying off.
+type ExternalOptions struct {
+	Client       *http.Client
+	Concurrency  int           // requests in flight at once, default 8
+	HostInterval time.Duration // least time between requests to one host, default 1s
+	Retries      int           // attempts after a network error, 429 or 5xx, default 2
+	RetryDelay   time.Duration // doubles after each retry, default 1s
+	UserAgent    string
+
+	// CacheFile keeps working URLs for TTL so reruns skip them; broken
+	// ones are checked again every time. Empty disables the cache.
+	CacheFile string
+	TTL       time.Duration // default 24h
+}
+
+func (o *ExternalOptions) setDefaults() {
+	if o.Client == nil {
+		o.Client = &http.Client{Timeout: 15 * time.Second}
+	}
+	if o.Concurrency < 1 {
+		o.Concurrency = 8
+	}
+	if o.HostInterval == 0 {
+		o.HostInterval = time.Second
+	}
+	if o.Retries == 0 {
+		o.Retries = 2
+	}
+	if o.RetryDelay == 0 {
+		o.RetryDelay = time.Second
+	}
+	if o.UserAgent == "" {
+		o.UserAgent = "Mozilla/5.0 (compatible; site link checker)"
+	}
+	if o.TTL == 0 {
+		o.TTL = 24 * time.Hour
+	}
+}
+
+// result is the outcome of checking one URL
+type result struct {
+	Status  int       `json:"status,omitempty"`
+	Err     string    `json:"error,omitempty"`
+	Checked time.Time `json:"checked"`
+}
+
+func (r result) ok() bool {
+	return r.Err == "" && r.Status < 400
+}
+
+func (r result) String() string {
+	if r.Err != "" {
+		return r.Err
+	}
+	return fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status))
+}
+
+// External checks every link to another site from the HTML files in the
+// output directory. Each URL is requested once however many pages link
+// to it; problems are reported for every link, sorted by file and line.
+func (c *Checker) External(ctx context.Context, opts ExternalOptions) ([]Problem, error) {
+	opts.setDefaults()
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	// Fragments aren't sent to servers, so URLs differing only in them
+	// are the same request
+	type occurrence struct {
+		file string
+		link Link
+	}
+	links := make(map[string][]occurrence)
+	for _, file := range c.files() {
+		for _, link := range c.pages[file].links {
+			u, err := url.Parse(link.URL)
+			if err != nil || !c.isExternal(u) {
+				continue
+			}
+			if u.Scheme == "" {
+				u.Scheme = "https"
+			}
+			u.Fragment = ""
+			links[u.String()] = append(links[u.String()], occurrence{file, link})
+		}
+	}
+
+	cache := loadCache(opts.CacheFile)
+	now := time.Now()
+	var urls []string
+	results := make(map[string]result)
+	for u := range links {
+		if cached, ok := cache[u]; ok && cached.ok() && now.Sub(cached.Checked) < opts.TTL {
+			results[u] = cached
+			continue
+		}
+		urls = append(urls, u)
+	}
+	sort.Strings(urls)
+
+	checked := newLinkChecker(opts).checkAll(ctx, urls)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for u, r := range checked {
+		results[u] = r
+		cache[u] = r
+	}
+	if err := saveCache(opts.CacheFile, cache, opts.TTL); err != nil {
+		return nil, err
+	}
+
+	var problems []Problem
+	for u, occurrences := range links {
+		r := results[u]
+		if r.ok() {
+			continue
+		}
+		for _, o := range occurrences {
+			problems = append(problems, Problem{File: o.file, Line: o.link.Line, URL: o.link.URL, Msg: r.String()})
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		if problems[i].File != problems[j].File {
+			return problems[i].File < problems[j].File
+		}
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].URL < problems[j].URL
+	})
+	return problems, nil
+}
+
+// isExternal reports whether u points at another site over HTTP
+func (c *Checker) isExternal(u *url.URL) bool {
+	switch u.Scheme {
+	case "http", "https":
+	case "":
+		if u.Host == "" {
+			return false
+		}
+	default:
+		return false
+	}
+	return !c.isInternal(u)
+}
+
+// linkChecker requests URLs, spacing out requests to the same host
+type linkChecker struct {
+	opts ExternalOptions
+
+	mu   sync.Mutex
+	next map[string]time.Time // earliest time of the next request per host
+}
+
+func newLinkChecker(opts ExternalOptions) *linkChecker {
+	return &linkChecker{opts: opts, next: make(map[string]time.Time)}
+}
+
+// checkAll checks urls with opts.Concurrency workers
+func (l *linkChecker) checkAll(ctx context.Context, urls []string) map[string]result {
+	jobs := make(chan string)
+	go func() {
+		defer close(jobs)
+		for _, u := range urls {
+			select {
+			case jobs <- u:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	results := make(map[string]result)
+	var wg sync.WaitGroup
+	for i := 0; i < l.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range jobs {
+				r := l.check(ctx, u)
+				mu.Lock()
+				results[u] = r
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// check requests u, retrying failures that may be temporary
+func (l *linkChecker) check(ctx context.Context, rawURL string) result {
+	delay := l.opts.RetryDelay
+	var r result
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		r, retryAfter = l.request(ctx, rawURL)
+		if attempt >= l.opts.Retries || !(r.Err != "" || r.Status == http.StatusTooManyRequests || r.Status >= 500) {
+			return r
+		}
+
+		wait := delay
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return r
+		}
+		delay *= 2
+	}
+}
+
+// maxRetryAfter caps how long a Retry-After header can hold up a check
+const maxRetryAfter = time.Minute
+
+// request sends a HEAD request, falling back to GET for servers that
+// reject or mishandle HEAD. It also returns the Retry-After of a 429.
+func (l *linkChecker) request(ctx context.Context, rawURL string) (result, time.Duration) {
+	var r result
+	var retryAfter time.Duration
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		if err := l.wait(ctx, rawURL); err != nil {
+			return result{Err: err.Error(), Checked: time.Now()}, 0
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+		if err != nil {
+			return result{Err: "invalid URL", Checked: time.Now()}, 0
+		}
+		req.Header.Set("User-Agent", l.opts.UserAgent)
+
+		resp, err := l.opts.Client.Do(req)
+		if err != nil {
+			r = result{Err: err.Error(), Checked: time.Now()}
+			continue
+		}
+		// Only the status matters; don't download pages
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+
+		r = result{Status: resp.StatusCode, Checked: time.Now()}
+		retryAfter = 0
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = min(time.Duration(seconds)*time.Second, maxRetryAfter)
+		}
+		if r.ok() || resp.StatusCode == http.StatusTooManyRequests {
+			break
+		}
+	}
+	return r, retryAfter
+}
+
+// wait blocks until the host of rawURL may get another request
+func (l *linkChecker) wait(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next[u.Host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[u.Host] = at.Add(l.opts.HostInterval)
+	l.mu.Unlock()
+
+	return sleep(ctx, time.Until(at))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// loadCache reads the results of earlier runs. A missing or unreadable
+// cache is empty.
+func loadCache(path string) map[string]result {
+	cache := make(map[string]result)
+	if path == "" {
+		return cache
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return make(map[string]result)
+	}
+	return cache
+}
+
+// saveCache writes the working URLs checked within ttl
+func saveCache(path string, cache map[string]result, ttl time.Duration) error {
+	if path == "" {
+		return nil
+	}
+
+	kept := make(map[string]result)
+	for u, r := range cache {
+		if r.ok() && time.Since(r.Checked) < ttl {
+			kept[u] = r
+		}
+	}
+	data, err := json.MarshalIndent(kept, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode link cache: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write link cache: %w", err)
+	}
+	return nil
+}
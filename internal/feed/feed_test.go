@@ -0,0 +1,145 @@
+package feed
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testFeed() Feed {
+	return Feed{
+		Title:       "Test Site - Blog",
+		Description: "Recent posts",
+		Link:        "https://example.com/blog/",
+		Dir:         "https://example.com/blog/",
+		Author:      "Jane Doe",
+		Items: []Item{
+			{
+				Title:     "Second Post",
+				Link:      "https://example.com/blog/second/",
+				Published: time.Date(2023, 10, 2, 0, 0, 0, 0, time.UTC),
+				Summary:   "Second summary",
+				Content:   "<p>Second <em>body</em></p>",
+				Tags:      []string{"go"},
+			},
+			{
+				Title:     "First Post",
+				Link:      "https://example.com/blog/first/",
+				Published: time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
+				Summary:   "First summary",
+			},
+		},
+	}
+}
+
+func TestRSS(t *testing.T) {
+	data, err := RSS(testFeed())
+	if err != nil {
+		t.Fatalf("RSS() error = %v", err)
+	}
+
+	var got struct {
+		Version string `xml:"version,attr"`
+		Channel struct {
+			Title         string `xml:"title"`
+			LastBuildDate string `xml:"lastBuildDate"`
+			Items         []struct {
+				Title       string `xml:"title"`
+				Link        string `xml:"link"`
+				PubDate     string `xml:"pubDate"`
+				Description string `xml:"description"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid XML: %v", err)
+	}
+
+	if got.Version != "2.0" {
+		t.Errorf("version = %q, want 2.0", got.Version)
+	}
+	if len(got.Channel.Items) != 2 {
+		t.Fatalf("items = %d, want 2", len(got.Channel.Items))
+	}
+	if got.Channel.LastBuildDate != "Mon, 02 Oct 2023 00:00:00 +0000" {
+		t.Errorf("lastBuildDate = %q, want newest item date", got.Channel.LastBuildDate)
+	}
+
+	first := got.Channel.Items[0]
+	if first.Description != "<p>Second <em>body</em></p>" {
+		t.Errorf("description = %q, want full content", first.Description)
+	}
+	if got.Channel.Items[1].Description != "First summary" {
+		t.Errorf("description = %q, want summary when content is empty", got.Channel.Items[1].Description)
+	}
+	if !strings.Contains(string(data), `href="https://example.com/blog/index.xml"`) {
+		t.Error("RSS should link to itself")
+	}
+}
+
+func TestAtom(t *testing.T) {
+	data, err := Atom(testFeed())
+	if err != nil {
+		t.Fatalf("Atom() error = %v", err)
+	}
+
+	var got struct {
+		XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		Updated string   `xml:"updated"`
+		Author  string   `xml:"author>name"`
+		Entries []struct {
+			ID        string `xml:"id"`
+			Published string `xml:"published"`
+			Content   struct {
+				Type string `xml:"type,attr"`
+				Body string `xml:",chardata"`
+			} `xml:"content"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid XML: %v", err)
+	}
+
+	if got.Updated != "2023-10-02T00:00:00Z" {
+		t.Errorf("updated = %q, want newest item date", got.Updated)
+	}
+	if got.Author != "Jane Doe" {
+		t.Errorf("author = %q, want Jane Doe", got.Author)
+	}
+	if len(got.Entries) != 2 {
+		t.Fatalf("entries = %d, want 2", len(got.Entries))
+	}
+	if got.Entries[0].ID != "https://example.com/blog/second/" {
+		t.Errorf("id = %q, want absolute permalink", got.Entries[0].ID)
+	}
+	if got.Entries[0].Content.Type != "html" {
+		t.Errorf("content type = %q, want html", got.Entries[0].Content.Type)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	data, err := JSON(testFeed())
+	if err != nil {
+		t.Fatalf("JSON() error = %v", err)
+	}
+
+	var got jsonFeed
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+
+	if got.FeedURL != "https://example.com/blog/feed.json" {
+		t.Errorf("feed_url = %q", got.FeedURL)
+	}
+	if len(got.Items) != 2 {
+		t.Fatalf("items = %d, want 2", len(got.Items))
+	}
+	if got.Items[0].ContentHTML == "" || got.Items[0].DatePublished != "2023-10-02T00:00:00Z" {
+		t.Errorf("first item = %+v, want HTML content and date", got.Items[0])
+	}
+	if got.Items[1].ContentText != "First summary" {
+		t.Errorf("content_text = %q, want summary", got.Items[1].ContentText)
+	}
+}
@@ -0,0 +1,353 @@
+// Package imaging produces the resized copies of images shown at
+// different screen sizes. Images are decoded and re-encoded, which drops
+// EXIF, XMP and other metadata; JPEG orientation is applied first so
+// photos stay upright without it.
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// Formats the package reads and writes, as named by image.DecodeConfig
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+	GIF  = "gif"
+)
+
+// Image is a source image. Only its header is read until a copy is made.
+type Image struct {
+	Format   string
+	Width    int // as displayed, after orientation
+	Height   int
+	Animated bool // GIFs with several frames, which are never resized
+
+	data        []byte
+	orientation int
+	decoded     image.Image
+	gif         *gif.GIF // every frame, read by decodeGIF
+}
+
+// Inspect reads the format and dimensions of a JPEG, PNG or GIF
+func Inspect(data []byte) (*Image, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	img := &Image{Format: format, Width: cfg.Width, Height: cfg.Height, data: data, orientation: 1}
+	switch format {
+	case JPEG:
+		img.orientation = orientation(data)
+		if img.orientation >= 5 {
+			img.Width, img.Height = img.Height, img.Width
+		}
+	case PNG:
+	case GIF:
+		frames, err := gifFrames(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+		img.Animated = frames > 1
+	default:
+		return nil, fmt.Errorf("unsupported image format %s", format)
+	}
+	return img, nil
+}
+
+// Widths returns the widths to make copies at: those of widths narrower
+// than the image and the image's own, smallest first. Animated GIFs only
+// get their own.
+func (img *Image) Widths(widths []int) []int {
+	out := []int{img.Width}
+	if img.Animated {
+		return out
+	}
+	for _, w := range widths {
+		if w > 0 && w < img.Width && !contains(out, w) {
+			out = append(out, w)
+		}
+	}
+	sort.Ints(out)
+	return out
+}
+
+// HeightAt returns the height of a copy width pixels wide
+func (img *Image) HeightAt(width int) int {
+	return max(1, (img.Height*width+img.Width/2)/img.Width)
+}
+
+// Resize encodes a copy width pixels wide in the image's format. quality
+// applies to JPEGs.
+func (img *Image) Resize(width, quality int) ([]byte, error) {
+	if img.Animated {
+		if width != img.Width {
+			return nil, fmt.Errorf("animated GIFs can't be resized")
+		}
+		g, err := img.decodeGIF()
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, &gif.GIF{
+			Image:     g.Image,
+			Delay:     g.Delay,
+			LoopCount: g.LoopCount,
+			Disposal:  g.Disposal,
+			Config:    g.Config,
+		}); err != nil {
+			return nil, fmt.Errorf("failed to encode image: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	src, err := img.decode()
+	if err != nil {
+		return nil, err
+	}
+
+	var dst image.Image = src
+	if width != img.Width {
+		scaled := image.NewNRGBA(image.Rect(0, 0, width, img.HeightAt(width)))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, src.Bounds(), draw.Src, nil)
+		dst = scaled
+	}
+
+	var buf bytes.Buffer
+	switch img.Format {
+	case JPEG:
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality})
+	case PNG:
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, dst)
+	case GIF:
+		// Keep the original colors instead of the default palette
+		frame := img.gif.Image[0]
+		paletted := image.NewPaletted(dst.Bounds(), frame.Palette)
+		draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), dst, dst.Bounds().Min)
+		err = gif.Encode(&buf, paletted, &gif.Options{NumColors: len(frame.Palette)})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decode reads the pixels once, upright
+func (img *Image) decode() (image.Image, error) {
+	if img.decoded != nil {
+		return img.decoded, nil
+	}
+
+	var src image.Image
+	if img.Format == GIF {
+		g, err := img.decodeGIF()
+		if err != nil {
+			return nil, err
+		}
+		src = g.Image[0]
+	} else {
+		var err error
+		if src, _, err = image.Decode(bytes.NewReader(img.data)); err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+	}
+	img.decoded = orient(src, img.orientation)
+	return img.decoded, nil
+}
+
+// decodeGIF reads every frame of a GIF once
+func (img *Image) decodeGIF() (*gif.GIF, error) {
+	if img.gif == nil {
+		g, err := gif.DecodeAll(bytes.NewReader(img.data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode image: %w", err)
+		}
+		img.gif = g
+	}
+	return img.gif, nil
+}
+
+// gifFrames counts the frames of a GIF, stopping at the second, by walking
+// its blocks without decompressing them
+func gifFrames(data []byte) (int, error) {
+	errTruncated := fmt.Errorf("gif: truncated data")
+	if len(data) < 13 {
+		return 0, errTruncated
+	}
+
+	// Header and logical screen descriptor, then the global color table
+	i := 13
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&7 + 1)
+	}
+
+	// subBlocks skips data sub-blocks up to the empty one ending them
+	subBlocks := func() bool {
+		for i < len(data) {
+			size := int(data[i])
+			i += 1 + size
+			if size == 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	frames := 0
+	for frames < 2 {
+		if i >= len(data) {
+			return 0, errTruncated
+		}
+		switch data[i] {
+		case 0x21: // extension: label and sub-blocks
+			i += 2
+			if !subBlocks() {
+				return 0, errTruncated
+			}
+		case 0x2c: // image descriptor, local color table, LZW code size, sub-blocks
+			if i+10 > len(data) {
+				return 0, errTruncated
+			}
+			packed := data[i+9]
+			i += 10
+			if packed&0x80 != 0 {
+				i += 3 << (packed&7 + 1)
+			}
+			i++
+			if !subBlocks() {
+				return 0, errTruncated
+			}
+			frames++
+		case 0x3b: // trailer
+			if frames == 0 {
+				return 0, fmt.Errorf("gif: no image data")
+			}
+			return frames, nil
+		default:
+			return 0, fmt.Errorf("gif: unknown block type %#x", data[i])
+		}
+	}
+	return frames, nil
+}
+
+// orientation returns the EXIF orientation of a JPEG, 1 (upright) when it
+// has none. 2 to 8 are the mirrored and rotated variants, 5 to 8 being
+// stored on their side.
+func orientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return 1
+	}
+
+	// Walk the segments before the image data for the APP1 Exif one
+	for i := 2; i+4 <= len(data) && data[i] == 0xff; {
+		marker := data[i+1]
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// tiffOrientation reads the orientation tag of the first IFD of the TIFF
+// structure EXIF is stored in
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 0 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for n := 0; n < count; n++ {
+		entry := ifd + 2 + n*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orient turns src upright for an EXIF orientation
+func orient(src image.Image, o int) image.Image {
+	if o <= 1 || o > 8 {
+		return src
+	}
+
+	b := src.Bounds()
+	in := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(in, in.Bounds(), src, b.Min, draw.Src)
+
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	if o >= 5 {
+		dw, dh = sh, sw
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case 2: // mirrored
+				sx, sy = sw-1-x, y
+			case 3: // upside down
+				sx, sy = sw-1-x, sh-1-y
+			case 4: // mirrored upside down
+				sx, sy = x, sh-1-y
+			case 5: // mirrored on its left side
+				sx, sy = y, x
+			case 6: // on its left side
+				sx, sy = y, sh-1-x
+			case 7: // mirrored on its right side
+				sx, sy = sw-1-y, sh-1-x
+			case 8: // on its right side
+				sx, sy = sw-1-y, x
+			}
+			copy(out.Pix[out.PixOffset(x, y):][:4], in.Pix[in.PixOffset(sx, sy):][:4])
+		}
+	}
+	return out
+}
+
+func contains(list []int, v int) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,258 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+// testImage is white on the left half and black on the right
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{0xff, 0xff, 0xff, 0xff}
+			if x >= w/2 {
+				c = color.RGBA{0, 0, 0, 0xff}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image, orientation uint16) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	if orientation == 0 {
+		return buf.Bytes()
+	}
+
+	// An APP1 segment with a one-entry IFD holding the orientation
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	for _, v := range []interface{}{uint16(42), uint32(8), uint16(1), uint16(0x0112), uint16(3), uint32(1), orientation, uint16(0), uint32(0)} {
+		binary.Write(&tiff, binary.BigEndian, v)
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	app1 := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	data := buf.Bytes()
+	return append(append(append([]byte(nil), data[:2]...), app1...), data[2:]...)
+}
+
+func TestInspect(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, testImage(30, 20)); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := func() *image.Paletted {
+		return image.NewPaletted(image.Rect(0, 0, 10, 10), palette.Plan9)
+	}
+	var still, animated bytes.Buffer
+	if err := gif.EncodeAll(&still, &gif.GIF{Image: []*image.Paletted{frame()}, Delay: []int{0}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gif.EncodeAll(&animated, &gif.GIF{Image: []*image.Paletted{frame(), frame()}, Delay: []int{10, 10}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		format   string
+		width    int
+		height   int
+		animated bool
+		wantErr  bool
+	}{
+		{"jpeg", encodeJPEG(t, testImage(40, 20), 0), JPEG, 40, 20, false, false},
+		{"jpeg on its side", encodeJPEG(t, testImage(40, 20), 6), JPEG, 20, 40, false, false},
+		{"jpeg upside down", encodeJPEG(t, testImage(40, 20), 3), JPEG, 40, 20, false, false},
+		{"png", pngData.Bytes(), PNG, 30, 20, false, false},
+		{"gif", still.Bytes(), GIF, 10, 10, false, false},
+		{"animated gif", animated.Bytes(), GIF, 10, 10, true, false},
+		{"truncated gif", animated.Bytes()[:animated.Len()/2], "", 0, 0, false, true},
+		{"not an image", []byte("<svg/>"), "", 0, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Inspect(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Inspect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if img.Format != tt.format || img.Width != tt.width || img.Height != tt.height || img.Animated != tt.animated {
+				t.Errorf("Inspect() = %s %dx%d animated %v, want %s %dx%d animated %v",
+					img.Format, img.Width, img.Height, img.Animated, tt.format, tt.width, tt.height, tt.animated)
+			}
+			if img.decoded != nil || img.gif != nil {
+				t.Error("Inspect() decoded the pixels")
+			}
+		})
+	}
+}
+
+func TestWidths(t *testing.T) {
+	img := &Image{Width: 1000, Height: 500}
+	tests := []struct {
+		widths []int
+		want   []int
+	}{
+		{[]int{480, 800, 1200}, []int{480, 800, 1000}},
+		{[]int{800, 480, 800}, []int{480, 800, 1000}},
+		{[]int{1000, 2000}, []int{1000}},
+		{nil, []int{1000}},
+	}
+	for _, tt := range tests {
+		if got := img.Widths(tt.widths); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Widths(%v) = %v, want %v", tt.widths, got, tt.want)
+		}
+	}
+
+	img.Animated = true
+	if got := img.Widths([]int{480}); !reflect.DeepEqual(got, []int{1000}) {
+		t.Errorf("Widths() of an animated GIF = %v, want [1000]", got)
+	}
+
+	if got := (&Image{Width: 3, Height: 2}).HeightAt(2); got != 1 {
+		t.Errorf("HeightAt(2) = %d, want 1", got)
+	}
+}
+
+func TestResize(t *testing.T) {
+	data := encodeJPEG(t, testImage(40, 20), 6)
+	img, err := Inspect(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, width := range []int{20, 10} {
+		out, err := img.Resize(width, 80)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(out, []byte("Exif")) {
+			t.Errorf("Resize(%d) kept the EXIF segment", width)
+		}
+
+		decoded, err := jpeg.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decoded.Bounds().Size(); got != image.Pt(width, width*2) {
+			t.Fatalf("Resize(%d) size = %v, want %dx%d", width, got, width, width*2)
+		}
+
+		// Turned upright, the white half is on top
+		top, _, _, _ := decoded.At(width/2, 1).RGBA()
+		bottom, _, _, _ := decoded.At(width/2, width*2-2).RGBA()
+		if top < 0xc000 || bottom > 0x4000 {
+			t.Errorf("Resize(%d) top = %#x, bottom = %#x, want white over black", width, top, bottom)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(30, 20)); err != nil {
+		t.Fatal(err)
+	}
+	if img, err = Inspect(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	out, err := img.Resize(15, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := png.DecodeConfig(bytes.NewReader(out)); err != nil || cfg.Width != 15 || cfg.Height != 10 {
+		t.Errorf("Resize(15) = %+v, %v, want a 15x10 PNG", cfg, err)
+	}
+}
+
+func TestResizeGIF(t *testing.T) {
+	frame := func() *image.Paletted {
+		return image.NewPaletted(image.Rect(0, 0, 20, 10), palette.Plan9)
+	}
+	for _, frames := range []int{1, 3} {
+		g := &gif.GIF{}
+		for i := 0; i < frames; i++ {
+			g.Image = append(g.Image, frame())
+			g.Delay = append(g.Delay, 10)
+		}
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, g); err != nil {
+			t.Fatal(err)
+		}
+
+		img, err := Inspect(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		width := 10
+		if img.Animated {
+			width = 20
+		}
+		out, err := img.Resize(width, 80)
+		if err != nil {
+			t.Fatalf("Resize(%d) of %d frames: %v", width, frames, err)
+		}
+		got, err := gif.DecodeAll(bytes.NewReader(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Image) != frames || got.Config.Width != width {
+			t.Errorf("Resize(%d) of %d frames = %d frames %d wide", width, frames, len(got.Image), got.Config.Width)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 2x1 image: red, green
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	red, green := color.NRGBA{0xff, 0, 0, 0xff}, color.NRGBA{0, 0xff, 0, 0xff}
+	src.Set(0, 0, red)
+	src.Set(1, 0, green)
+
+	tests := []struct {
+		orientation int
+		want        [][]color.NRGBA // rows
+	}{
+		{1, [][]color.NRGBA{{red, green}}},
+		{2, [][]color.NRGBA{{green, red}}},
+		{3, [][]color.NRGBA{{green, red}}},
+		{4, [][]color.NRGBA{{red, green}}},
+		{5, [][]color.NRGBA{{red}, {green}}},
+		{6, [][]color.NRGBA{{red}, {green}}},
+		{7, [][]color.NRGBA{{green}, {red}}},
+		{8, [][]color.NRGBA{{green}, {red}}},
+	}
+	for _, tt := range tests {
+		got := orient(src, tt.orientation)
+		if got.Bounds().Dy() != len(tt.want) || got.Bounds().Dx() != len(tt.want[0]) {
+			t.Errorf("orient(%d) size = %v", tt.orientation, got.Bounds().Size())
+			continue
+		}
+		for y, row := range tt.want {
+			for x, want := range row {
+				if c := color.NRGBAModel.Convert(got.At(x, y)); c != want {
+					t.Errorf("orient(%d) at %d,%d = %v, want %v", tt.orientation, x, y, c, want)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sporollan/site/internal/site"
+	"gopkg.in/yaml.v2"
+)
+
+// FrontMatter holds the known front matter fields of a page. Other keys
+// stay available through Page.Metadata.
+type FrontMatter struct {
+	Title       string
+	Description string
+	Summary     string
+	Slug        string
+	Template    string
+	Author      string
+	Image       string
+	Date        time.Time
+	Lastmod     time.Time
+	PublishDate time.Time
+	ExpiryDate  time.Time
+	Weight      int
+	Paginate    int
+	Draft       bool
+	Sitemap     bool
+	TOC         bool
+	TOCMinDepth int
+	TOCMaxDepth int
+	Tags        []string
+	Categories  []string
+	Aliases     []string
+}
+
+// Error is a problem at a line of a source file
+type Error struct {
+	Path string
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Msg)
+}
+
+// dateLayouts are the date formats accepted in front matter
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+// fieldDecoder converts front matter values, collecting an Error at the
+// key's line for every value of the wrong type
+type fieldDecoder struct {
+	path     string
+	lines    map[string]int
+	metadata map[string]interface{}
+	errs     []error
+}
+
+func (d *fieldDecoder) fail(key, format string, args ...interface{}) {
+	d.errs = append(d.errs, &Error{
+		Path: d.path,
+		Line: d.lines[key],
+		Msg:  fmt.Sprintf("%s: %s", key, fmt.Sprintf(format, args...)),
+	})
+}
+
+func (d *fieldDecoder) str(key string) string {
+	switch v := d.metadata[key].(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int, int64, float64, bool:
+		return fmt.Sprint(v)
+	default:
+		d.fail(key, "want a string, got %s", describe(v))
+		return ""
+	}
+}
+
+// list accepts a list of scalars or a single scalar
+func (d *fieldDecoder) list(key string) []string {
+	var list []string
+	switch v := d.metadata[key].(type) {
+	case nil:
+	case string:
+		list = append(list, v)
+	case []interface{}:
+		for _, item := range v {
+			switch item := item.(type) {
+			case string:
+				list = append(list, item)
+			case int, int64, float64, bool:
+				list = append(list, fmt.Sprint(item))
+			default:
+				d.fail(key, "want a list of strings, got an item of %s", describe(item))
+			}
+		}
+	default:
+		d.fail(key, "want a list of strings, got %s", describe(v))
+	}
+	return list
+}
+
+func (d *fieldDecoder) integer(key string) int {
+	switch v := d.metadata[key].(type) {
+	case nil:
+		return 0
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		if v == float64(int(v)) {
+			return int(v)
+		}
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	d.fail(key, "want an integer, got %s", describe(d.metadata[key]))
+	return 0
+}
+
+func (d *fieldDecoder) boolean(key string, fallback bool) bool {
+	switch v := d.metadata[key].(type) {
+	case nil:
+		return fallback
+	case bool:
+		return v
+	default:
+		d.fail(key, "want true or false, got %s", describe(v))
+		return fallback
+	}
+}
+
+func (d *fieldDecoder) date(key string) time.Time {
+	switch v := d.metadata[key].(type) {
+	case nil:
+		return time.Time{}
+	case time.Time:
+		return v
+	case string:
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t
+			}
+		}
+		d.fail(key, "cannot parse %q as a date, want YYYY-MM-DD or RFC 3339", v)
+	default:
+		d.fail(key, "want a date, got %s", describe(v))
+	}
+	return time.Time{}
+}
+
+func describe(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "nothing"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []interface{}, []map[string]interface{}:
+		return "a list"
+	case map[interface{}]interface{}, map[string]interface{}:
+		return "a mapping"
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// decodeFrontMatter converts metadata into the known fields. All problems
+// are reported together, each with the line of its key in data.
+func decodeFrontMatter(path string, data []byte, metadata map[string]interface{}) (FrontMatter, error) {
+	d := &fieldDecoder{path: path, lines: keyLines(data), metadata: metadata}
+
+	fm := FrontMatter{
+		Title:       d.str("title"),
+		Description: d.str("description"),
+		Summary:     d.str("summary"),
+		Slug:        d.str("slug"),
+		Template:    d.str("template"),
+		Author:      d.str("author"),
+		Image:       d.str("image"),
+		Date:        d.date("date"),
+		Lastmod:     d.date("lastmod"),
+		PublishDate: d.date("publishDate"),
+		ExpiryDate:  d.date("expiryDate"),
+		Weight:      d.integer("weight"),
+		Paginate:    d.integer("paginate"),
+		Draft:       d.boolean("draft", false),
+		Sitemap:     d.boolean("sitemap", true),
+		TOC:         d.boolean("toc", true),
+		TOCMinDepth: d.integer("tocMinDepth"),
+		TOCMaxDepth: d.integer("tocMaxDepth"),
+		Tags:        d.list("tags"),
+		Categories:  d.list("categories"),
+		Aliases:     d.list("aliases"),
+	}
+	if fm.Paginate < 0 {
+		d.fail("paginate", "want 0 or more pages, got %d", fm.Paginate)
+	}
+
+	return fm, errors.Join(d.errs...)
+}
+
+// frontMatterFormat is a front matter syntax, recognized by how a file
+// starts
+type frontMatterFormat struct {
+	delimiter string         // line opening and closing the front matter, empty for JSON
+	key       *regexp.Regexp // captures the name of a top-level key
+	end       *regexp.Regexp // a line after the top-level keys, if any
+	unmarshal func(data []byte, metadata map[string]interface{}) error
+}
+
+var (
+	yamlFormat = &frontMatterFormat{
+		delimiter: "---",
+		key:       regexp.MustCompile(`^["']?([A-Za-z0-9_-]+)["']?\s*:`),
+		unmarshal: unmarshalYAML,
+	}
+	tomlFormat = &frontMatterFormat{
+		delimiter: "+++",
+		key:       regexp.MustCompile(`^\s*["']?([A-Za-z0-9_-]+)["']?\s*=`),
+		end:       regexp.MustCompile(`^\s*\[`),
+		unmarshal: unmarshalTOML,
+	}
+	jsonFormat = &frontMatterFormat{
+		key:       regexp.MustCompile(`^\s*"([^"]+)"\s*:`),
+		unmarshal: unmarshalJSON,
+	}
+)
+
+// jsonStart tells JSON front matter from markdown that happens to start
+// with a brace, such as a shortcode
+var jsonStart = regexp.MustCompile(`^\{\s*["}]`)
+
+func detectFormat(content string) *frontMatterFormat {
+	switch {
+	case strings.HasPrefix(content, "---"):
+		return yamlFormat
+	case strings.HasPrefix(content, "+++"):
+		return tomlFormat
+	case jsonStart.MatchString(content):
+		return jsonFormat
+	}
+	return nil
+}
+
+// yamlLine matches the line number in YAML syntax errors
+var yamlLine = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// The unmarshal functions report errors at lines counted from the start
+// of the front matter.
+
+func unmarshalYAML(data []byte, metadata map[string]interface{}) error {
+	if err := yaml.Unmarshal(data, &metadata); err != nil {
+		if m := yamlLine.FindStringSubmatch(err.Error()); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return &Error{Line: line, Msg: m[2]}
+		}
+		return &Error{Line: 1, Msg: err.Error()}
+	}
+	return nil
+}
+
+func unmarshalTOML(data []byte, metadata map[string]interface{}) error {
+	if _, err := toml.Decode(string(data), &metadata); err != nil {
+		var perr toml.ParseError
+		if errors.As(err, &perr) {
+			return &Error{Line: perr.Position.Line, Msg: perr.Message}
+		}
+		return &Error{Line: 1, Msg: err.Error()}
+	}
+
+	// TOML local dates carry the machine's zone; YAML dates are UTC
+	for key, value := range metadata {
+		if t, ok := value.(time.Time); ok && strings.HasSuffix(t.Location().String(), "-local") {
+			metadata[key] = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+		}
+	}
+	return nil
+}
+
+func unmarshalJSON(data []byte, metadata map[string]interface{}) error {
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return jsonError(string(data), err)
+	}
+	return nil
+}
+
+// jsonError locates a JSON decoding error in content
+func jsonError(content string, err error) error {
+	var offset int64
+	var syntax *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntax):
+		// The offset is past the offending character
+		offset = syntax.Offset - 1
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return &Error{Line: 1, Msg: err.Error()}
+	}
+	offset = max(min(offset, int64(len(content))), 0)
+
+	return &Error{
+		Line: strings.Count(content[:offset], "\n") + 1,
+		Msg:  strings.TrimPrefix(err.Error(), "json: "),
+	}
+}
+
+// keyLines maps each top-level front matter key to its line in data,
+// counting from 1
+func keyLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+
+	block, err := splitFrontMatter(data)
+	if err != nil || block.format == nil {
+		return lines
+	}
+
+	for n, line := range strings.Split(string(block.raw), "\n") {
+		if block.format.end != nil && block.format.end.MatchString(line) {
+			break
+		}
+		if m := block.format.key.FindStringSubmatch(line); m != nil {
+			if _, seen := lines[m[1]]; !seen {
+				lines[m[1]] = block.line + n
+			}
+		}
+	}
+
+	return lines
+}
+
+// closingLine returns the line of the delimiter or brace closing the front
+// matter of data, 1 when it has none
+func closingLine(data []byte) int {
+	block, err := splitFrontMatter(data)
+	if err != nil || block.format == nil {
+		return 1
+	}
+	return block.line + bytes.Count(block.raw, []byte("\n"))
+}
+
+// Validate checks a page's front matter against the schema of its section:
+// required fields must be set and fields with allowed values may only use
+// those.
+func Validate(path string, data []byte, metadata map[string]interface{}, schema site.SchemaConfig) error {
+	lines := keyLines(data)
+	var errs []error
+
+	// Missing fields belong at the end of the front matter
+	for _, key := range schema.Required {
+		if isEmpty(metadata[key]) {
+			errs = append(errs, &Error{Path: path, Line: closingLine(data), Msg: fmt.Sprintf("missing required field %q", key)})
+		}
+	}
+
+	keys := make([]string, 0, len(schema.Allowed))
+	for key := range schema.Allowed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		allowed := schema.Allowed[key]
+		d := &fieldDecoder{path: path, lines: lines, metadata: metadata}
+		for _, value := range d.list(key) {
+			if !contains(allowed, value) {
+				d.fail(key, "%q is not one of %s", value, strings.Join(allowed, ", "))
+			}
+		}
+		errs = append(errs, d.errs...)
+	}
+
+	// Report in file order
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].(*Error).Line < errs[j].(*Error).Line
+	})
+	return errors.Join(errs...)
+}
+
+func isEmpty(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
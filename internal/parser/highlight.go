@@ -0,0 +1,285 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/sporollan/site/internal/site"
+	"github.com/yuin/goldmark/ast"
+	gmparser "github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// codeOptions are the settings of a fenced code block, written in braces
+// after the language:
+//
+//	```go {linenos=true, linenostart=10, hl_lines=[3,"5-7"], title="main.go"}
+type codeOptions struct {
+	lang        string
+	lineNumbers bool
+	lineStart   int
+	highlight   [][2]int // line ranges counted from the first line of the block
+	title       string   // shown in a caption above the code, usually a file name
+}
+
+// parseCodeOptions reads the info string of a fenced code block
+func parseCodeOptions(info string, cfg site.HighlightConfig) (codeOptions, error) {
+	opts := codeOptions{lineNumbers: cfg.LineNumbers, lineStart: 1}
+
+	info = strings.TrimSpace(info)
+	attrs := ""
+	if i := strings.IndexByte(info, '{'); i >= 0 {
+		info, attrs = strings.TrimSpace(info[:i]), info[i:]
+	}
+	if fields := strings.Fields(info); len(fields) > 0 {
+		opts.lang = fields[0]
+	}
+	if attrs == "" {
+		return opts, nil
+	}
+
+	parsed, ok := gmparser.ParseAttributes(text.NewReader([]byte(attrs)))
+	if !ok {
+		return opts, fmt.Errorf("cannot parse code block options %s", attrs)
+	}
+
+	for _, attr := range parsed {
+		name := string(attr.Name)
+		switch name {
+		case "linenos":
+			v, ok := attr.Value.(bool)
+			if !ok {
+				return opts, fmt.Errorf("%s: want true or false, got %s", name, attrString(attr.Value))
+			}
+			opts.lineNumbers = v
+		case "linenostart":
+			v, ok := attr.Value.(float64)
+			if !ok || v < 1 || v != float64(int(v)) {
+				return opts, fmt.Errorf("%s: want a line number, got %s", name, attrString(attr.Value))
+			}
+			opts.lineStart = int(v)
+		case "hl_lines":
+			ranges, err := lineRanges(attr.Value)
+			if err != nil {
+				return opts, fmt.Errorf("%s: %w", name, err)
+			}
+			opts.highlight = ranges
+		case "title", "filename":
+			v, ok := attr.Value.([]byte)
+			if !ok {
+				return opts, fmt.Errorf("%s: want a string, got %s", name, attrString(attr.Value))
+			}
+			opts.title = string(v)
+		}
+	}
+
+	return opts, nil
+}
+
+// lineRanges reads hl_lines: a list of line numbers and "from-to" ranges,
+// or the same separated by spaces in a single string
+func lineRanges(value interface{}) ([][2]int, error) {
+	var items []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		items = v
+	case float64:
+		items = []interface{}{v}
+	case []byte:
+		for _, field := range strings.Fields(string(v)) {
+			items = append(items, []byte(field))
+		}
+	default:
+		return nil, fmt.Errorf("want a list of lines, got %s", attrString(value))
+	}
+
+	var ranges [][2]int
+	for _, item := range items {
+		switch item := item.(type) {
+		case float64:
+			if item < 1 || item != float64(int(item)) {
+				return nil, fmt.Errorf("%v is not a line number", item)
+			}
+			ranges = append(ranges, [2]int{int(item), int(item)})
+		case []byte:
+			from, to, isRange := strings.Cut(string(item), "-")
+			if !isRange {
+				to = from
+			}
+			start, err1 := strconv.Atoi(from)
+			end, err2 := strconv.Atoi(to)
+			if err1 != nil || err2 != nil || start < 1 || end < start {
+				return nil, fmt.Errorf("%q is not a line or range of lines", item)
+			}
+			ranges = append(ranges, [2]int{start, end})
+		default:
+			return nil, fmt.Errorf("want a list of lines, got an item of %s", attrString(item))
+		}
+	}
+	return ranges, nil
+}
+
+func attrString(v interface{}) string {
+	if b, ok := v.([]byte); ok {
+		return strconv.Quote(string(b))
+	}
+	return describe(v)
+}
+
+// codeBlockRenderer highlights fenced code blocks with chroma. Tokens get
+// CSS classes rather than inline colors, so the stylesheet from
+// HighlightCSS can follow the light and dark themes.
+type codeBlockRenderer struct {
+	cfg site.HighlightConfig
+}
+
+func (r *codeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *codeBlockRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkSkipChildren, nil
+	}
+	n := node.(*ast.FencedCodeBlock)
+
+	opts := codeOptions{lineNumbers: r.cfg.LineNumbers, lineStart: 1}
+	if n.Info != nil {
+		var err error
+		start := n.Info.Segment.Start
+		opts, err = parseCodeOptions(string(n.Info.Segment.Value(source)), r.cfg)
+		if err != nil {
+			return ast.WalkStop, &Error{Line: bytes.Count(source[:start], []byte("\n")) + 1, Msg: err.Error()}
+		}
+	}
+
+	var code bytes.Buffer
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		code.Write(segment.Value(source))
+	}
+
+	lexer := lexers.Get(opts.lang)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code.String())
+	if err != nil {
+		return ast.WalkStop, err
+	}
+
+	// Chroma numbers highlighted lines from linenostart
+	ranges := make([][2]int, len(opts.highlight))
+	for i, lines := range opts.highlight {
+		ranges[i] = [2]int{lines[0] + opts.lineStart - 1, lines[1] + opts.lineStart - 1}
+	}
+
+	formatter := chromahtml.New(
+		chromahtml.WithClasses(true),
+		chromahtml.WithLineNumbers(opts.lineNumbers),
+		chromahtml.BaseLineNumber(opts.lineStart),
+		chromahtml.HighlightLines(ranges),
+		chromahtml.WithPreWrapper(codeWrapper{lang: opts.lang}),
+	)
+
+	if opts.title != "" {
+		_, _ = w.WriteString(`<figure class="code-block"><figcaption>` + html.EscapeString(opts.title) + "</figcaption>")
+	}
+	if err := formatter.Format(w, styles.Fallback, tokens); err != nil {
+		return ast.WalkStop, err
+	}
+	if opts.title != "" {
+		_, _ = w.WriteString("</figure>")
+	}
+	_ = w.WriteByte('\n')
+
+	return ast.WalkSkipChildren, nil
+}
+
+// codeWrapper keeps the language class goldmark puts on code elements
+type codeWrapper struct {
+	lang string
+}
+
+func (c codeWrapper) Start(code bool, styleAttr string) string {
+	if !code {
+		return "<pre" + styleAttr + ">"
+	}
+	if c.lang == "" {
+		return "<pre" + styleAttr + "><code>"
+	}
+	lang := html.EscapeString(c.lang)
+	return "<pre" + styleAttr + `><code class="language-` + lang + `" data-lang="` + lang + `">`
+}
+
+func (c codeWrapper) End(code bool) string {
+	if code {
+		return "</code></pre>"
+	}
+	return "</pre>"
+}
+
+// HighlightCSS returns the stylesheet for highlighted code blocks. The
+// light style applies when theme-toggle.js sets the theme-light class on
+// the html element, or before it runs when the browser prefers a light
+// color scheme; the dark style applies otherwise.
+func HighlightCSS(cfg site.HighlightConfig) ([]byte, error) {
+	light, ok := styles.Registry[strings.ToLower(cfg.LightStyle)]
+	if !ok {
+		return nil, fmt.Errorf("unknown highlight style %q", cfg.LightStyle)
+	}
+	dark, ok := styles.Registry[strings.ToLower(cfg.DarkStyle)]
+	if !ok {
+		return nil, fmt.Errorf("unknown highlight style %q", cfg.DarkStyle)
+	}
+
+	formatter := chromahtml.New(
+		chromahtml.WithClasses(true),
+		chromahtml.WithLineNumbers(true),
+		chromahtml.WithCSSComments(false),
+	)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "/* Generated from the %s and %s chroma styles */\n", cfg.DarkStyle, cfg.LightStyle)
+
+	for _, theme := range []struct {
+		style    *chroma.Style
+		selected string // the theme chosen with the toggle
+		fallback string // the theme the browser prefers
+		media    string
+	}{
+		{dark, "html.theme-dark", "html:not(.theme-light)", "not all and (prefers-color-scheme: light)"},
+		{light, "html.theme-light", "html:not(.theme-dark)", "(prefers-color-scheme: light)"},
+	} {
+		var rules bytes.Buffer
+		if err := formatter.WriteCSS(&rules, theme.style); err != nil {
+			return nil, fmt.Errorf("failed to generate %s styles: %w", theme.style.Name, err)
+		}
+
+		buf.WriteString("\n")
+		writeScoped(&buf, rules.String(), theme.selected, "")
+		fmt.Fprintf(&buf, "@media %s {\n", theme.media)
+		writeScoped(&buf, rules.String(), theme.fallback, "  ")
+		buf.WriteString("}\n")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeScoped writes CSS rules, one per line, with scope before every
+// selector
+func writeScoped(buf *bytes.Buffer, rules, scope, indent string) {
+	for _, rule := range strings.Split(strings.TrimSpace(rules), "\n") {
+		buf.WriteString(indent + scope + " " + rule + "\n")
+	}
+}
@@ -0,0 +1,213 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"html"
+
+	"github.com/sporollan/site/internal/site"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// Render hook kinds. Each is rendered by the hook of the same name, such
+// as templates/_hooks/link.html.
+const (
+	HookLink      = "link"
+	HookImage     = "image"
+	HookHeading   = "heading"
+	HookCodeBlock = "codeblock"
+)
+
+// Hooks render markdown elements in place of the built-in HTML
+type Hooks interface {
+	HasHook(kind string) bool
+	RenderHook(kind string, ctx *site.HookContext) (string, error)
+}
+
+// pathMeta is the document meta key holding the source path
+const pathMeta = "path"
+
+func (p *Parser) hasHook(kind string) bool {
+	return p.hooks != nil && p.hooks.HasHook(kind)
+}
+
+// hookRenderer renders the elements that have hooks. It is registered
+// ahead of the built-in renderers, and only for those elements.
+type hookRenderer struct {
+	p *Parser
+}
+
+func (r *hookRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	if r.p.hasHook(HookLink) {
+		reg.Register(ast.KindLink, r.renderLink)
+		reg.Register(ast.KindAutoLink, r.renderAutoLink)
+	}
+	if r.p.hasHook(HookImage) {
+		reg.Register(ast.KindImage, r.renderImage)
+	}
+	if r.p.hasHook(HookHeading) {
+		reg.Register(ast.KindHeading, r.renderHeading)
+	}
+	if r.p.hasHook(HookCodeBlock) {
+		reg.Register(ast.KindFencedCodeBlock, r.renderCodeBlock)
+	}
+}
+
+// hook writes the output of the hook for an element, reporting errors at
+// the element's line
+func (r *hookRenderer) hook(w util.BufWriter, source []byte, n ast.Node, kind string, ctx *site.HookContext) (ast.WalkStatus, error) {
+	ctx.Path, _ = n.OwnerDocument().Meta()[pathMeta].(string)
+
+	output, err := r.p.hooks.RenderHook(kind, ctx)
+	if err != nil {
+		return ast.WalkStop, &Error{Line: nodeLine(n, source), Msg: err.Error()}
+	}
+	_, _ = w.WriteString(output)
+	return ast.WalkSkipChildren, nil
+}
+
+// children renders the content of n
+func (r *hookRenderer) children(source []byte, n ast.Node) (string, error) {
+	var buf bytes.Buffer
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if err := r.p.md.Renderer().Render(&buf, source, c); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+func (r *hookRenderer) renderLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Link)
+
+	text, err := r.children(source, n)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	return r.hook(w, source, n, HookLink, &site.HookContext{
+		Destination: string(n.Destination),
+		Title:       string(n.Title),
+		Text:        text,
+		PlainText:   nodeText(n, source),
+	})
+}
+
+func (r *hookRenderer) renderAutoLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.AutoLink)
+
+	url := string(n.URL(source))
+	if n.AutoLinkType == ast.AutoLinkEmail && !bytes.HasPrefix(bytes.ToLower(n.URL(source)), []byte("mailto:")) {
+		url = "mailto:" + url
+	}
+	label := string(n.Label(source))
+	return r.hook(w, source, n, HookLink, &site.HookContext{
+		Destination: url,
+		Text:        html.EscapeString(label),
+		PlainText:   label,
+	})
+}
+
+func (r *hookRenderer) renderImage(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Image)
+
+	alt := nodeText(n, source)
+	return r.hook(w, source, n, HookImage, &site.HookContext{
+		Destination: string(n.Destination),
+		Title:       string(n.Title),
+		Text:        html.EscapeString(alt),
+		PlainText:   alt,
+	})
+}
+
+func (r *hookRenderer) renderHeading(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.Heading)
+
+	text, err := r.children(source, n)
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	id, _ := n.AttributeString("id")
+	idBytes, _ := id.([]byte)
+	return r.hook(w, source, n, HookHeading, &site.HookContext{
+		Level:     n.Level,
+		Anchor:    string(idBytes),
+		Text:      text,
+		PlainText: nodeText(n, source),
+	})
+}
+
+// renderCodeBlock passes the block's highlighted HTML to the hook along
+// with its source
+func (r *hookRenderer) renderCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.FencedCodeBlock)
+
+	var code bytes.Buffer
+	lines := n.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		segment := lines.At(i)
+		code.Write(segment.Value(source))
+	}
+
+	var lang string
+	if n.Info != nil {
+		opts, err := parseCodeOptions(string(n.Info.Segment.Value(source)), r.p.cfg.Highlight)
+		if err == nil {
+			lang = opts.lang
+		}
+	}
+
+	var text bytes.Buffer
+	if r.p.cfg.Highlight.Enabled {
+		bw := bufio.NewWriter(&text)
+		if _, err := (&codeBlockRenderer{cfg: r.p.cfg.Highlight}).renderFencedCodeBlock(bw, source, n, true); err != nil {
+			return ast.WalkStop, err
+		}
+		bw.Flush()
+	} else {
+		text.WriteString("<pre><code")
+		if lang != "" {
+			text.WriteString(` class="language-` + html.EscapeString(lang) + `"`)
+		}
+		text.WriteString(">" + html.EscapeString(code.String()) + "</code></pre>\n")
+	}
+
+	return r.hook(w, source, n, HookCodeBlock, &site.HookContext{
+		Lang: lang,
+		Code: code.String(),
+		Text: text.String(),
+	})
+}
+
+// nodeLine returns the line of the closest block holding n, counting from
+// the start of source
+func nodeLine(n ast.Node, source []byte) int {
+	for ; n != nil; n = n.Parent() {
+		if n.Type() != ast.TypeBlock {
+			continue
+		}
+		if fenced, ok := n.(*ast.FencedCodeBlock); ok && fenced.Info != nil {
+			return bytes.Count(source[:fenced.Info.Segment.Start], []byte("\n")) + 1
+		}
+		if lines := n.Lines(); lines != nil && lines.Len() > 0 {
+			return bytes.Count(source[:lines.At(0).Start], []byte("\n")) + 1
+		}
+	}
+	return 1
+}
@@ -0,0 +1,266 @@
+package parser
+
+import (
+	"bytes"
+	"html"
+	"strings"
+
+	"github.com/sporollan/site/internal/site"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	gmparser "github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Parser turns source files into pages with the markup settings of a site
+type Parser struct {
+	cfg   site.MarkupConfig
+	hooks Hooks
+	md    goldmark.Markdown
+}
+
+func New(cfg site.MarkupConfig) *Parser {
+	p := &Parser{cfg: cfg}
+	p.md = p.markdown()
+	return p
+}
+
+// SetHooks makes p render the elements h has hooks for with h. It must be
+// called before p is used.
+func (p *Parser) SetHooks(h Hooks) {
+	p.hooks = h
+	p.md = p.markdown()
+}
+
+// markdown creates the goldmark converter for the parser's settings
+func (p *Parser) markdown() goldmark.Markdown {
+	options := []goldmark.Option{
+		goldmark.WithExtensions(extensions(p.cfg.Extensions)...),
+		goldmark.WithParserOptions(gmparser.WithAutoHeadingID()),
+	}
+	if p.cfg.Unsafe {
+		options = append(options, goldmark.WithRendererOptions(gmhtml.WithUnsafe()))
+	}
+	if p.cfg.Highlight.Enabled {
+		options = append(options, goldmark.WithRendererOptions(
+			renderer.WithNodeRenderers(util.Prioritized(&codeBlockRenderer{cfg: p.cfg.Highlight}, 100)),
+		))
+	}
+	if p.hooks != nil {
+		options = append(options, goldmark.WithRendererOptions(
+			renderer.WithNodeRenderers(util.Prioritized(&hookRenderer{p: p}, 50)),
+		))
+	}
+
+	return goldmark.New(options...)
+}
+
+// extensions lists the goldmark extensions turned on in cfg
+func extensions(cfg site.ExtensionsConfig) []goldmark.Extender {
+	var list []goldmark.Extender
+	for _, ext := range []struct {
+		enabled  bool
+		extender goldmark.Extender
+	}{
+		{cfg.Table, extension.Table},
+		{cfg.Strikethrough, extension.Strikethrough},
+		{cfg.TaskList, extension.TaskList},
+		{cfg.Linkify, extension.Linkify},
+		{cfg.Footnote, extension.Footnote},
+		{cfg.DefinitionList, extension.DefinitionList},
+		{cfg.Typographer, extension.Typographer},
+	} {
+		if ext.enabled {
+			list = append(list, ext.extender)
+		}
+	}
+	return list
+}
+
+var defaultParser = New(site.DefaultConfig().Markup)
+
+// Parse parses a page with the default markup settings
+func Parse(path string, data []byte) (site.Page, error) {
+	return defaultParser.Parse(path, data)
+}
+
+// heading is a heading found while rendering, before depth filtering
+type heading struct {
+	level int
+	text  string
+	id    string
+}
+
+// render converts the markdown of the source file at path to HTML
+func (p *Parser) render(path string, source []byte) (string, []heading, error) {
+	doc, headings, err := p.parse(path, source)
+	if err != nil {
+		return "", nil, err
+	}
+	html, err := p.renderNodes(source, doc)
+	return html, headings, err
+}
+
+// parse reads the markdown of the source file at path. Headings get
+// unique ids, and anchor links when enabled and not left to a heading
+// hook. Links to markdown files get placeholders for ExpandRefs.
+func (p *Parser) parse(path string, source []byte) (ast.Node, []heading, error) {
+	doc := p.md.Parser().Parse(text.NewReader(source), gmparser.WithContext(gmparser.NewContext()))
+	doc.OwnerDocument().AddMeta(pathMeta, path)
+	anchors := p.cfg.HeadingAnchors && !p.hasHook(HookHeading)
+
+	var headings []heading
+	err := ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if link, ok := n.(*ast.Link); ok && isRef(string(link.Destination)) {
+			link.Destination = []byte(refPlaceholder(string(link.Destination)))
+		}
+		h, ok := n.(*ast.Heading)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+
+		id, _ := h.AttributeString("id")
+		idBytes, _ := id.([]byte)
+		headings = append(headings, heading{
+			level: h.Level,
+			text:  nodeText(h, source),
+			id:    string(idBytes),
+		})
+
+		// The link is empty so summaries and feeds don't pick up a stray
+		// "#"; the stylesheet draws it
+		if anchors && len(idBytes) > 0 {
+			link := ast.NewLink()
+			link.Destination = append([]byte("#"), idBytes...)
+			link.SetAttributeString("class", []byte("heading-anchor"))
+			link.SetAttributeString("title", []byte("Link to this section"))
+			h.AppendChild(h, link)
+		}
+		return ast.WalkContinue, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, headings, nil
+}
+
+// renderNodes converts parsed nodes to HTML, one after the other
+func (p *Parser) renderNodes(source []byte, nodes ...ast.Node) (string, error) {
+	var buf bytes.Buffer
+	for _, n := range nodes {
+		if err := p.md.Renderer().Render(&buf, source, n); err != nil {
+			return "", err
+		}
+	}
+	return buf.String(), nil
+}
+
+// moreSeparator returns the top-level HTML block holding just the
+// summary separator, if doc has one
+func moreSeparator(doc ast.Node, source []byte) ast.Node {
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		block, ok := n.(*ast.HTMLBlock)
+		if !ok || block.HTMLBlockType != ast.HTMLBlockType2 {
+			continue
+		}
+		var text []byte
+		lines := block.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			text = append(text, segment.Value(source)...)
+		}
+		if block.HasClosure() {
+			text = append(text, block.ClosureLine.Value(source)...)
+		}
+		if string(bytes.TrimSpace(text)) == MoreSeparator {
+			return block
+		}
+	}
+	return nil
+}
+
+// nodeText returns the plain text inside n
+func nodeText(n ast.Node, source []byte) string {
+	var b strings.Builder
+	_ = ast.Walk(n, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Text:
+			b.Write(n.Segment.Value(source))
+			if n.SoftLineBreak() || n.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(b.String())
+}
+
+// tableOfContents nests the headings between minDepth and maxDepth
+// under the closest preceding heading of a higher level
+func tableOfContents(headings []heading, minDepth, maxDepth int) []*site.TOCEntry {
+	var root []*site.TOCEntry
+	var stack []*site.TOCEntry
+
+	for _, h := range headings {
+		// Entries never nest across a heading above the listed levels
+		if h.level < minDepth {
+			stack = nil
+			continue
+		}
+		if h.level > maxDepth || h.id == "" {
+			continue
+		}
+
+		entry := &site.TOCEntry{Level: h.level, Text: h.text, ID: h.id}
+		for len(stack) > 0 && stack[len(stack)-1].Level >= h.level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			root = append(root, entry)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, entry)
+		}
+		stack = append(stack, entry)
+	}
+
+	return root
+}
+
+// tableOfContentsHTML renders entries as nested lists
+func tableOfContentsHTML(entries []*site.TOCEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	var list func(entries []*site.TOCEntry)
+	list = func(entries []*site.TOCEntry) {
+		b.WriteString("<ul>")
+		for _, entry := range entries {
+			b.WriteString(`<li><a href="#` + html.EscapeString(entry.ID) + `">` + html.EscapeString(entry.Text) + "</a>")
+			if len(entry.Children) > 0 {
+				list(entry.Children)
+			}
+			b.WriteString("</li>")
+		}
+		b.WriteString("</ul>")
+	}
+
+	b.WriteString(`<nav class="toc" aria-label="Table of contents">`)
+	list(entries)
+	b.WriteString("</nav>")
+	return b.String()
+}
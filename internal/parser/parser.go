@@ -2,107 +2,206 @@ package parser
 
 import (
 	"bytes"
+	"html"
 	"path/filepath"
 	"strings"
-	"time"
 
 	"github.com/sporollan/site/internal/site"
-	"github.com/yuin/goldmark"
-	"gopkg.in/yaml.v2"
+	"github.com/yuin/goldmark/ast"
 )
 
-var markdownConverter = goldmark.New(
-	goldmark.WithExtensions(),
-)
+// Version is bumped whenever Parse produces different pages from the same
+// source and settings, so builds don't reuse pages parsed by an older
+// version
+const Version = 3
 
-// parseFrontMatter splits YAML front matter from markdown content
+// parseFrontMatter splits YAML, TOML or JSON front matter from markdown
+// content. The returned content is a suffix of data, so its offset in the
+// file is known.
 func parseFrontMatter(data []byte) (map[string]interface{}, []byte, error) {
-	content := string(data)
-
-	// Check if file starts with front matter
-	if !strings.HasPrefix(content, "---") {
-		return nil, data, nil
+	block, err := splitFrontMatter(data)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Find the second "---"
-	parts := strings.SplitN(content, "---", 3)
-	if len(parts) < 3 {
-		return nil, data, nil
+	// Check if file starts with front matter
+	if block.format == nil {
+		return nil, block.body, nil
 	}
 
-	frontMatterStr := parts[1]
-	markdownContent := []byte(strings.TrimSpace(parts[2]))
-
 	metadata := make(map[string]interface{})
-	err := yaml.Unmarshal([]byte(frontMatterStr), &metadata)
-	if err != nil {
-		return nil, markdownContent, err
+	if err := block.format.unmarshal(block.raw, metadata); err != nil {
+		// Lines in decoding errors count from the start of the front matter
+		if e, ok := err.(*Error); ok {
+			e.Line += block.line - 1
+		}
+		return nil, block.body, err
 	}
 
-	return metadata, markdownContent, nil
+	return metadata, block.body, nil
 }
 
-func Parse(path string, data []byte) (site.Page, error) {
+// ReadMetadata decodes the front matter of a source file without rendering
+// its body. It returns the same values as the Metadata of a parsed page.
+func ReadMetadata(data []byte) (map[string]interface{}, error) {
+	metadata, _, err := parseFrontMatter(data)
+	return metadata, err
+}
+
+// Parse reads the front matter and renders the markdown of a source file
+func (p *Parser) Parse(path string, data []byte) (site.Page, error) {
 	// Parse front matter
 	metadata, markdownContent, err := parseFrontMatter(data)
 	if err != nil {
-		return site.Page{}, err
+		if e, ok := err.(*Error); ok {
+			e.Path = path
+			return site.Page{}, e
+		}
+		return site.Page{}, &Error{Path: path, Line: 1, Msg: err.Error()}
 	}
 
-	// Convert markdown to HTML
-	var htmlBuf bytes.Buffer
-	if err := markdownConverter.Convert(markdownContent, &htmlBuf); err != nil {
+	// The body is a suffix of data; count the lines before it
+	bodyLine := bytes.Count(data[:len(data)-len(markdownContent)], []byte("\n")) + 1
+
+	fm, err := decodeFrontMatter(path, data, metadata)
+	if err != nil {
 		return site.Page{}, err
 	}
 
-	// Extract title (from front matter or filename)
-	baseName := filepath.Base(path)
-	title := strings.TrimSuffix(baseName, filepath.Ext(baseName))
-	title = strings.Title(strings.ReplaceAll(title, "-", " ")) // "about-me" -> "About Me"
+	// Lines in rendering errors count from the start of the body
+	renderError := func(err error) error {
+		if e, ok := err.(*Error); ok {
+			e.Path = path
+			e.Line += bodyLine - 1
+			return e
+		}
+		return err
+	}
 
-	if mdTitle, ok := metadata["title"].(string); ok && mdTitle != "" {
-		title = mdTitle
+	// Replace shortcode calls with placeholders
+	rawBody := string(markdownContent)
+	content, shortcodes, err := p.extractShortcodes(path, string(markdownContent))
+	if err != nil {
+		return site.Page{}, renderError(err)
+	}
+	for i := range shortcodes {
+		shortcodes[i].Line += bodyLine - 1
 	}
 
-	// Extract template name
-	templateName := "page.html" // default
-	if tmpl, ok := metadata["template"].(string); ok && tmpl != "" {
-		templateName = tmpl
+	// Convert markdown to HTML
+	source := []byte(content)
+	doc, headings, err := p.parse(path, source)
+	if err != nil {
+		return site.Page{}, renderError(err)
 	}
 
-	// Extract date if present
-	var pageDate time.Time
-	if dateStr, ok := metadata["date"].(string); ok {
-		if date, err := time.Parse("2006-01-02", dateStr); err == nil {
-			pageDate = date
+	// Blocks before a <!--more--> line are the summary. The whole page is
+	// parsed first so references and footnotes defined later still resolve.
+	var summaryHTML string
+	var truncated bool
+	separator := moreSeparator(doc, source)
+	more := separator != nil
+	if more {
+		var blocks []ast.Node
+		for n := doc.FirstChild(); n != separator; n = n.NextSibling() {
+			blocks = append(blocks, n)
+		}
+		if summaryHTML, err = p.renderNodes(source, blocks...); err != nil {
+			return site.Page{}, renderError(err)
 		}
+		truncated = separator.NextSibling() != nil
+		doc.RemoveChild(doc, separator)
+	}
+
+	body, err := p.renderNodes(source, doc)
+	if err != nil {
+		return site.Page{}, renderError(err)
 	}
 
-	// Extract other metadata
-	tags := []string{}
-	if tagsInterface, ok := metadata["tags"].([]interface{}); ok {
-		for _, tag := range tagsInterface {
-			if tagStr, ok := tag.(string); ok {
-				tags = append(tags, tagStr)
-			}
+	var anchors []string
+	for _, h := range headings {
+		if h.id != "" {
+			anchors = append(anchors, h.id)
 		}
 	}
 
-	// Extract draft status
-	draft := false
-	if draftVal, ok := metadata["draft"].(bool); ok {
-		draft = draftVal
+	// Front matter can narrow the table of contents or turn it off
+	var toc []*site.TOCEntry
+	if fm.TOC {
+		minDepth, maxDepth := p.cfg.TOC.MinDepth, p.cfg.TOC.MaxDepth
+		if fm.TOCMinDepth > 0 {
+			minDepth = fm.TOCMinDepth
+		}
+		if fm.TOCMaxDepth > 0 {
+			maxDepth = fm.TOCMaxDepth
+		}
+		toc = tableOfContents(headings, minDepth, maxDepth)
+	}
+
+	// Extract title (from front matter or filename)
+	title := fm.Title
+	if title == "" {
+		baseName := filepath.Base(path)
+		title = strings.TrimSuffix(baseName, filepath.Ext(baseName))
+		title = strings.Title(strings.ReplaceAll(title, "-", " ")) // "about-me" -> "About Me"
+	}
+
+	// Extract template name
+	templateName := "page.html" // default
+	if fm.Template != "" {
+		templateName = fm.Template
+	}
+
+	// Without a separator, a summary or description in front matter is used
+	var summary string
+	switch {
+	case more:
+		summary, _, _ = Summarize(summaryHTML, 0)
+	case fm.Summary != "":
+		summary = fm.Summary
+	case fm.Description != "":
+		summary = fm.Description
+	}
+	if summary != "" && !more {
+		summaryHTML = html.EscapeString(summary)
+	}
+
+	// Scheduled pages are dated when they go live unless set otherwise
+	date := fm.Date
+	if date.IsZero() {
+		date = fm.PublishDate
 	}
 
 	return site.Page{
 		Path:         path,
 		Title:        title,
-		Body:         htmlBuf.String(),
-		RawBody:      string(markdownContent),
+		Body:         body,
+		RawBody:      rawBody,
+		BodyLine:     bodyLine,
 		TemplateName: templateName,
-		Date:         pageDate,
-		Draft:        draft,
-		Tags:         tags,
+		Date:         date,
+		Lastmod:      fm.Lastmod,
+		PublishDate:  fm.PublishDate,
+		ExpiryDate:   fm.ExpiryDate,
+		Draft:        fm.Draft,
+		NoSitemap:    !fm.Sitemap,
+		Weight:       fm.Weight,
+		Paginate:     fm.Paginate,
+		Slug:         fm.Slug,
+		Aliases:      fm.Aliases,
+		Author:       fm.Author,
+		Image:        fm.Image,
+		Tags:         fm.Tags,
+		Categories:   fm.Categories,
+		Summary:      summary,
+		SummaryHTML:  summaryHTML,
+		Truncated:    truncated,
+		Description:  fm.Description,
 		Metadata:     metadata,
+
+		TableOfContents:     toc,
+		TableOfContentsHTML: tableOfContentsHTML(toc),
+		Anchors:             anchors,
+		Shortcodes:          shortcodes,
 	}, nil
 }
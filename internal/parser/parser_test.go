@@ -1,6 +1,9 @@
 package parser
 
 import (
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -41,8 +44,9 @@ template: "post.html"
 
 Content here.`),
 			wantPage: site.Page{
-				Title: "My Post",
-				// Don't check Description if parser doesn't extract it
+				Title:        "My Post",
+				Description:  "A test post",
+				Summary:      "A test post",
 				TemplateName: "post.html",
 				Path:         "content/blog/post.md",
 				Date:         time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
@@ -70,6 +74,112 @@ Should not be published.`),
 			},
 			wantErr: false,
 		},
+		{
+			name: "markdown excluded from sitemap",
+			path: "content/hidden.md",
+			data: []byte(`---
+title: "Hidden"
+sitemap: false
+---
+
+Not listed.`),
+			wantPage: site.Page{
+				Title:        "Hidden",
+				TemplateName: "page.html",
+				Path:         "content/hidden.md",
+				NoSitemap:    true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "toml front matter",
+			path: "content/blog/toml.md",
+			data: []byte(`+++
+title = "TOML Post"
+date = 2023-10-01
+tags = ["go", "hugo"]
+description = "Imported"
+draft = false
+
+[params]
+series = "Import"
++++
+
+# Heading
+
+Content.`),
+			wantPage: site.Page{
+				Title:        "TOML Post",
+				Description:  "Imported",
+				Summary:      "Imported",
+				TemplateName: "page.html",
+				Path:         "content/blog/toml.md",
+				Date:         time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
+				Tags:         []string{"go", "hugo"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "json front matter",
+			path: "content/blog/json.md",
+			data: []byte(`{
+  "title": "JSON Post",
+  "date": "2023-10-01",
+  "tags": ["go"],
+  "weight": 2,
+  "template": "post.html"
+}
+
+Content.`),
+			wantPage: site.Page{
+				Title:        "JSON Post",
+				TemplateName: "post.html",
+				Path:         "content/blog/json.md",
+				Date:         time.Date(2023, 10, 1, 0, 0, 0, 0, time.UTC),
+				Tags:         []string{"go"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "summary before more separator",
+			path: "content/more.md",
+			data: []byte(`---
+title: "More"
+summary: "Ignored"
+---
+First *paragraph*.
+
+<!--more-->
+
+Rest of the post.`),
+			wantPage: site.Page{
+				Title:        "More",
+				TemplateName: "page.html",
+				Path:         "content/more.md",
+				Summary:      "First paragraph.",
+				SummaryHTML:  "<p>First <em>paragraph</em>.</p>\n",
+				Truncated:    true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "summary from front matter",
+			path: "content/summary.md",
+			data: []byte(`---
+summary: "Fish & chips"
+description: "Ignored"
+---
+Body.`),
+			wantPage: site.Page{
+				Title:        "Summary",
+				TemplateName: "page.html",
+				Path:         "content/summary.md",
+				Description:  "Ignored",
+				Summary:      "Fish & chips",
+				SummaryHTML:  "Fish &amp; chips",
+			},
+			wantErr: false,
+		},
 		{
 			name: "empty file",
 			path: "content/empty.md",
@@ -113,11 +223,31 @@ Should not be published.`),
 				t.Errorf("Draft = %v, want %v", got.Draft, tt.wantPage.Draft)
 			}
 
+			if got.NoSitemap != tt.wantPage.NoSitemap {
+				t.Errorf("NoSitemap = %v, want %v", got.NoSitemap, tt.wantPage.NoSitemap)
+			}
+
 			// Check description if expected
 			if tt.wantPage.Description != "" && got.Description != tt.wantPage.Description {
 				t.Errorf("Description = %v, want %v", got.Description, tt.wantPage.Description)
 			}
 
+			if got.Summary != tt.wantPage.Summary {
+				t.Errorf("Summary = %q, want %q", got.Summary, tt.wantPage.Summary)
+			}
+
+			if tt.wantPage.SummaryHTML != "" && got.SummaryHTML != tt.wantPage.SummaryHTML {
+				t.Errorf("SummaryHTML = %q, want %q", got.SummaryHTML, tt.wantPage.SummaryHTML)
+			}
+
+			if got.Truncated != tt.wantPage.Truncated {
+				t.Errorf("Truncated = %v, want %v", got.Truncated, tt.wantPage.Truncated)
+			}
+
+			if strings.Contains(got.Body, "more") && strings.Contains(got.Body, "omitted") {
+				t.Errorf("Body still contains the more separator: %s", got.Body)
+			}
+
 			// Check tags if expected
 			if len(tt.wantPage.Tags) > 0 {
 				if len(got.Tags) != len(tt.wantPage.Tags) {
@@ -138,6 +268,54 @@ Should not be published.`),
 	}
 }
 
+func TestParseMoreSeparator(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		summaryHTML  string
+		truncated    bool
+		bodyContains []string
+	}{
+		{
+			name:        "whole page before the separator",
+			data:        "Only paragraph.\n\n<!--more-->\n",
+			summaryHTML: "<p>Only paragraph.</p>\n",
+		},
+		{
+			name:         "separator in a code block",
+			data:         "Intro.\n\n```\n<!--more-->\n```\n\nRest.",
+			bodyContains: []string{"&lt;!--more--&gt;", "<p>Rest.</p>"},
+		},
+		{
+			name:         "references defined after the separator",
+			data:         "See [the docs][docs] and this[^1].\n\n<!--more-->\n\n[docs]: https://example.com/docs\n\n[^1]: A note.",
+			summaryHTML:  `<p>See <a href="https://example.com/docs">the docs</a> and this<sup id="fnref:1"><a href="#fn:1" class="footnote-ref" role="doc-noteref">1</a></sup>.</p>` + "\n",
+			truncated:    true,
+			bodyContains: []string{"A note."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse("content/post.md", []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.SummaryHTML != tt.summaryHTML {
+				t.Errorf("SummaryHTML = %q, want %q", got.SummaryHTML, tt.summaryHTML)
+			}
+			if got.Truncated != tt.truncated {
+				t.Errorf("Truncated = %v, want %v", got.Truncated, tt.truncated)
+			}
+			for _, want := range tt.bodyContains {
+				if !strings.Contains(got.Body, want) {
+					t.Errorf("Body = %q, want it to contain %q", got.Body, want)
+				}
+			}
+		})
+	}
+}
+
 func TestParseFrontMatter(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -180,6 +358,43 @@ title: "Test"
 			wantContent: "",
 			wantErr:     false,
 		},
+		{
+			name:        "shortcode is not json front matter",
+			data:        []byte(`{{< figure src="a.png" >}}`),
+			wantMeta:    nil,
+			wantContent: `{{< figure src="a.png" >}}`,
+			wantErr:     false,
+		},
+		{
+			name:        "crlf line endings and byte order mark",
+			data:        []byte("\xef\xbb\xbf---\r\ntitle: \"Test\"\r\n---\r\nContent\r\n"),
+			wantMeta:    map[string]interface{}{"title": "Test"},
+			wantContent: "Content\r\n",
+			wantErr:     false,
+		},
+		{
+			name: "dashes inside values and a rule after the header",
+			data: []byte(`---
+title: "Before --- after"
+---
+---
+Content`),
+			wantMeta:    map[string]interface{}{"title": "Before --- after"},
+			wantContent: "---\nContent",
+			wantErr:     false,
+		},
+		{
+			name:        "delimiter must be alone on its line",
+			data:        []byte("--- title\nContent"),
+			wantMeta:    nil,
+			wantContent: "--- title\nContent",
+			wantErr:     false,
+		},
+		{
+			name:    "unterminated front matter",
+			data:    []byte("---\ntitle: \"Test\"\nContent"),
+			wantErr: true,
+		},
 		{
 			name: "only front matter delimiter",
 			data: []byte(`---
@@ -193,7 +408,7 @@ Content`),
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, content, err := parseFrontMatter(tt.data)
+			meta, content, err := parseFrontMatter(tt.data)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseFrontMatter() error = %v, wantErr %v", err, tt.wantErr)
@@ -204,6 +419,12 @@ Content`),
 				return
 			}
 
+			for key, want := range tt.wantMeta {
+				if got, ok := meta[key].(string); ok && got != want {
+					t.Errorf("parseFrontMatter() %s = %v, want %v", key, got, want)
+				}
+			}
+
 			// Check content
 			if string(content) != tt.wantContent {
 				t.Errorf(
@@ -215,3 +436,825 @@ Content`),
 		})
 	}
 }
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		limit         int
+		wantText      string
+		wantHTML      string
+		wantTruncated bool
+	}{
+		{
+			name:     "short text is kept",
+			body:     "<p>Hello world.</p>\n",
+			limit:    150,
+			wantText: "Hello world.",
+			wantHTML: "<p>Hello world.</p>\n",
+		},
+		{
+			name:          "cut on a word boundary",
+			body:          "<p>The quick brown fox jumps</p>",
+			limit:         17,
+			wantText:      "The quick brown…",
+			wantHTML:      "<p>The quick brown…</p>",
+			wantTruncated: true,
+		},
+		{
+			name:          "open tags are closed",
+			body:          "<p>One <em>two three</em> four</p>",
+			limit:         8,
+			wantText:      "One two…",
+			wantHTML:      "<p>One <em>two…</em></p>",
+			wantTruncated: true,
+		},
+		{
+			name:          "blocks separate words and markup is dropped",
+			body:          "<h2 id=\"why\">Why did I build a site?</h2>\n<p>After months</p>",
+			limit:         30,
+			wantText:      "Why did I build a site? After…",
+			wantHTML:      "<h2 id=\"why\">Why did I build a site?</h2>\n<p>After…</p>",
+			wantTruncated: true,
+		},
+		{
+			name:          "runes are not split",
+			body:          "<p>héllo wörld ñandú</p>",
+			limit:         12,
+			wantText:      "héllo wörld…",
+			wantHTML:      "<p>héllo wörld…</p>",
+			wantTruncated: true,
+		},
+		{
+			name:     "entities are decoded",
+			body:     "<p>Fish &amp; chips</p>",
+			limit:    0,
+			wantText: "Fish & chips",
+			wantHTML: "<p>Fish &amp; chips</p>",
+		},
+		{
+			name:          "long word is cut",
+			body:          "<p>Supercalifragilistic</p>",
+			limit:         5,
+			wantText:      "Super…",
+			wantHTML:      "<p>Super…</p>",
+			wantTruncated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, html, truncated := Summarize(tt.body, tt.limit)
+
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+			if html != tt.wantHTML {
+				t.Errorf("html = %q, want %q", html, tt.wantHTML)
+			}
+			if truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestParseFrontMatterFields(t *testing.T) {
+	data := []byte(`---
+title: "Typed"
+date: 2026-01-02T10:00
+lastmod: "2026-01-05T08:30:00Z"
+publishDate: 2026-01-01
+slug: typed-page
+weight: 3
+tags: go
+aliases: [/old/, /older/]
+author: Someone
+image: /images/cover.png
+---
+Body.`)
+
+	page, err := Parse("content/typed.md", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC); !page.Date.Equal(want) {
+		t.Errorf("Date = %v, want %v", page.Date, want)
+	}
+	if want := time.Date(2026, 1, 5, 8, 30, 0, 0, time.UTC); !page.Lastmod.Equal(want) {
+		t.Errorf("Lastmod = %v, want %v", page.Lastmod, want)
+	}
+	if page.PublishDate.IsZero() {
+		t.Error("PublishDate not set")
+	}
+	if page.Slug != "typed-page" || page.Weight != 3 || page.Author != "Someone" || page.Image != "/images/cover.png" {
+		t.Errorf("Slug, Weight, Author, Image = %q, %d, %q, %q", page.Slug, page.Weight, page.Author, page.Image)
+	}
+	if len(page.Tags) != 1 || page.Tags[0] != "go" {
+		t.Errorf("Tags = %v, want [go]", page.Tags)
+	}
+	if len(page.Aliases) != 2 || page.Aliases[1] != "/older/" {
+		t.Errorf("Aliases = %v", page.Aliases)
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "bad date",
+			data: "---\ntitle: Post\ndate: 2026-13-02\n---\nBody",
+			want: []string{`content/post.md:3: date: cannot parse "2026-13-02" as a date`},
+		},
+		{
+			name: "wrong types are all reported",
+			data: "---\nweight: heavy\ndraft: maybe\ntags: {a: b}\n---\nBody",
+			want: []string{
+				"content/post.md:2: weight: want an integer",
+				"content/post.md:3: draft: want true or false",
+				"content/post.md:4: tags: want a list of strings, got a mapping",
+			},
+		},
+		{
+			name: "toml wrong type",
+			data: "+++\ntitle = \"Post\"\nweight = \"heavy\"\n+++\nBody",
+			want: []string{"content/post.md:3: weight: want an integer"},
+		},
+		{
+			name: "negative page size",
+			data: "---\ntitle: Index\npaginate: -1\n---\n",
+			want: []string{"content/post.md:3: paginate: want 0 or more pages, got -1"},
+		},
+		{
+			name: "toml syntax error",
+			data: "+++\ntitle = \"Post\"\ntags = [\"a\"\n+++\nBody",
+			want: []string{"content/post.md:"},
+		},
+		{
+			name: "json wrong type",
+			data: "{\n  \"title\": \"Post\",\n  \"draft\": \"yes\"\n}\nBody",
+			want: []string{"content/post.md:3: draft: want true or false"},
+		},
+		{
+			name: "json syntax error",
+			data: "{\n  \"title\": \"Post\",\n  \"draft\": tru\n}\nBody",
+			want: []string{"content/post.md:3: invalid character"},
+		},
+		{
+			name: "yaml syntax error",
+			data: "---\ntitle: Post\ntags: [a, b\n---\nBody",
+			want: []string{"content/post.md:"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse("content/post.md", []byte(tt.data))
+			if err == nil {
+				t.Fatal("Parse() error = nil")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	schema := site.SchemaConfig{
+		Required: []string{"title", "date"},
+		Allowed:  map[string][]string{"categories": {"Notes", "Projects"}},
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "valid",
+			data: "---\ntitle: Post\ndate: 2026-01-02\ncategories: [Notes]\n---\n",
+		},
+		{
+			name: "missing date",
+			data: "---\ntitle: Post\n---\n",
+			want: []string{`content/blog/post.md:3: missing required field "date"`},
+		},
+		{
+			name: "missing from toml",
+			data: "+++\ntitle = \"Post\"\ncategories = [\"Notes\"]\n+++\n",
+			want: []string{`content/blog/post.md:4: missing required field "date"`},
+		},
+		{
+			name: "missing from json",
+			data: "{\n  \"title\": \"Post\"\n}\nBody",
+			want: []string{`content/blog/post.md:3: missing required field "date"`},
+		},
+		{
+			name: "no front matter",
+			data: "Body\n",
+			want: []string{`content/blog/post.md:1: missing required field "title"`},
+		},
+		{
+			name: "value not allowed",
+			data: "---\ntitle: Post\ndate: 2026-01-02\ncategories:\n  - Notes\n  - Rants\n---\n",
+			want: []string{`content/blog/post.md:4: categories: "Rants" is not one of Notes, Projects`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := Parse("content/blog/post.md", []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Validate(page.Path, []byte(tt.data), page.Metadata, schema)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Errorf("Validate() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Validate() error = nil")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseBodyLine(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"no front matter", "# Title\n", 1},
+		{"yaml", "---\ntitle: T\n---\n\n# Title\n", 4},
+		{"crlf", "---\r\ntitle: T\r\n---\r\nBody\r\n", 4},
+		{"json", "{\n  \"title\": \"T\"\n}\nBody\n", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := Parse("content/page.md", []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.BodyLine != tt.want {
+				t.Errorf("BodyLine = %d, want %d", page.BodyLine, tt.want)
+			}
+		})
+	}
+
+	_, err := Parse("content/open.md", []byte("---\ntitle: T\n"))
+	if err == nil || !strings.Contains(err.Error(), "content/open.md:1: unterminated front matter") {
+		t.Errorf("Parse() error = %v, want unterminated front matter naming the file", err)
+	}
+}
+
+func TestTableOfContents(t *testing.T) {
+	source := `---
+title: "Guide"
+---
+# Guide
+
+## Setup
+
+### Install *Go*
+
+## Setup
+
+#### Deep
+
+### Usage
+`
+
+	tests := []struct {
+		name     string
+		cfg      site.MarkupConfig
+		data     string
+		wantTOC  string
+		wantBody []string
+	}{
+		{
+			name:    "default depth",
+			cfg:     site.DefaultConfig().Markup,
+			data:    source,
+			wantTOC: `<nav class="toc" aria-label="Table of contents"><ul><li><a href="#setup">Setup</a><ul><li><a href="#install-go">Install Go</a></li></ul></li><li><a href="#setup-1">Setup</a><ul><li><a href="#usage">Usage</a></li></ul></li></ul></nav>`,
+			wantBody: []string{
+				`<h2 id="setup">Setup<a href="#setup" class="heading-anchor" title="Link to this section"></a></h2>`,
+				`<h2 id="setup-1">Setup`,
+				`<h4 id="deep">Deep`,
+			},
+		},
+		{
+			name:    "front matter depth without anchors",
+			cfg:     site.MarkupConfig{TOC: site.TOCConfig{MinDepth: 2, MaxDepth: 3}},
+			data:    strings.Replace(source, "title", "tocMinDepth: 3\ntocMaxDepth: 4\ntitle", 1),
+			wantTOC: `<nav class="toc" aria-label="Table of contents"><ul><li><a href="#install-go">Install Go</a></li><li><a href="#deep">Deep</a></li><li><a href="#usage">Usage</a></li></ul></nav>`,
+			wantBody: []string{
+				`<h2 id="setup">Setup</h2>`,
+			},
+		},
+		{
+			name:    "disabled",
+			cfg:     site.DefaultConfig().Markup,
+			data:    strings.Replace(source, "title", "toc: false\ntitle", 1),
+			wantTOC: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := New(tt.cfg).Parse("content/guide.md", []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if page.TableOfContentsHTML != tt.wantTOC {
+				t.Errorf("TableOfContentsHTML = %s, want %s", page.TableOfContentsHTML, tt.wantTOC)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(page.Body, want) {
+					t.Errorf("Body = %s, want it to contain %s", page.Body, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMarkupExtensions(t *testing.T) {
+	all := site.DefaultConfig().Markup
+
+	tests := []struct {
+		name    string
+		source  string
+		disable func(*site.MarkupConfig)
+		want    string
+	}{
+		{"table", "| a | b |\n|---|---|\n| 1 | 2 |", func(c *site.MarkupConfig) { c.Extensions.Table = false }, "<table>"},
+		{"strikethrough", "~~gone~~", func(c *site.MarkupConfig) { c.Extensions.Strikethrough = false }, "<del>gone</del>"},
+		{"task list", "- [x] done", func(c *site.MarkupConfig) { c.Extensions.TaskList = false }, `type="checkbox"`},
+		{"linkify", "see https://example.com", func(c *site.MarkupConfig) { c.Extensions.Linkify = false }, `<a href="https://example.com">`},
+		{"footnote", "text[^1]\n\n[^1]: note", func(c *site.MarkupConfig) { c.Extensions.Footnote = false }, `class="footnotes"`},
+		{"definition list", "Term\n: Definition", func(c *site.MarkupConfig) { c.Extensions.DefinitionList = false }, "<dl>"},
+		{"typographer", `"quoted"`, func(c *site.MarkupConfig) { c.Extensions.Typographer = false }, "&ldquo;quoted&rdquo;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := New(all).Parse("content/page.md", []byte(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(page.Body, tt.want) {
+				t.Errorf("enabled: Body = %s, want it to contain %s", page.Body, tt.want)
+			}
+
+			cfg := all
+			tt.disable(&cfg)
+			page, err = New(cfg).Parse("content/page.md", []byte(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(page.Body, tt.want) {
+				t.Errorf("disabled: Body = %s, want it not to contain %s", page.Body, tt.want)
+			}
+		})
+	}
+
+	t.Run("raw html", func(t *testing.T) {
+		source := `<div class="note">Hi</div>`
+
+		page, err := New(all).Parse("content/page.md", []byte(source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(page.Body, "raw HTML omitted") {
+			t.Errorf("safe: Body = %s", page.Body)
+		}
+
+		cfg := all
+		cfg.Unsafe = true
+		page, err = New(cfg).Parse("content/page.md", []byte(source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(page.Body, source) {
+			t.Errorf("unsafe: Body = %s", page.Body)
+		}
+	})
+}
+
+func TestHighlight(t *testing.T) {
+	cfg := site.DefaultConfig().Markup
+
+	tests := []struct {
+		name    string
+		source  string
+		want    []string
+		wantNot []string
+	}{
+		{
+			name:    "classes",
+			source:  "```go\nfunc main() {}\n```",
+			want:    []string{`<pre class="chroma"><code class="language-go" data-lang="go">`, `<span class="kd">func</span>`},
+			wantNot: []string{`class="ln"`, "style="},
+		},
+		{
+			name:   "line numbers",
+			source: "```go {linenos=true, linenostart=10}\na := 1\nb := 2\n```",
+			want:   []string{`<span class="ln">10</span>`, `<span class="ln">11</span>`},
+		},
+		{
+			name:    "highlighted lines",
+			source:  "```go {hl_lines=[1,\"3-4\"]}\na\nb\nc\nd\n```",
+			want:    []string{`<span class="line hl"><span class="cl"><span class="nx">a</span>`, `<span class="line hl"><span class="cl"><span class="nx">c</span>`, `<span class="line hl"><span class="cl"><span class="nx">d</span>`},
+			wantNot: []string{`<span class="line hl"><span class="cl"><span class="nx">b</span>`},
+		},
+		{
+			name:   "highlighted lines follow linenostart",
+			source: "```go {linenostart=5, hl_lines=\"2\"}\na\nb\n```",
+			want:   []string{`<span class="line hl"><span class="cl"><span class="nx">b</span>`},
+		},
+		{
+			name:   "caption",
+			source: "```hcl {title=\"main.tf\"}\nresource \"x\" \"y\" {}\n```",
+			want:   []string{`<figure class="code-block"><figcaption>main.tf</figcaption><pre class="chroma">`, "</pre></figure>"},
+		},
+		{
+			name:   "unknown language",
+			source: "```nosuchlang\n<b>\n```",
+			want:   []string{`<code class="language-nosuchlang" data-lang="nosuchlang">`, "&lt;b&gt;"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := New(cfg).Parse("content/post.md", []byte(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(page.Body, want) {
+					t.Errorf("Body = %s, want it to contain %s", page.Body, want)
+				}
+			}
+			for _, unwanted := range tt.wantNot {
+				if strings.Contains(page.Body, unwanted) {
+					t.Errorf("Body = %s, want it not to contain %s", page.Body, unwanted)
+				}
+			}
+		})
+	}
+
+	t.Run("bad options", func(t *testing.T) {
+		source := "---\ntitle: Post\n---\n\nText\n\n```go {hl_lines=[0]}\nx\n```\n"
+		_, err := New(cfg).Parse("content/post.md", []byte(source))
+		want := "content/post.md:7: hl_lines: 0 is not a line number"
+		if err == nil || err.Error() != want {
+			t.Errorf("err = %v, want %s", err, want)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		off := cfg
+		off.Highlight.Enabled = false
+		page, err := New(off).Parse("content/post.md", []byte("```go\nx\n```"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `<pre><code class="language-go">x`; !strings.Contains(page.Body, want) {
+			t.Errorf("Body = %s, want it to contain %s", page.Body, want)
+		}
+	})
+}
+
+func TestHighlightCSS(t *testing.T) {
+	cfg := site.DefaultConfig().Markup.Highlight
+
+	css, err := HighlightCSS(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"html.theme-dark .chroma .k {",
+		"html.theme-light .chroma .k {",
+		"@media (prefers-color-scheme: light) {\n  html:not(.theme-dark) ",
+		"@media not all and (prefers-color-scheme: light) {\n  html:not(.theme-light) ",
+	} {
+		if !strings.Contains(string(css), want) {
+			t.Errorf("css missing %q", want)
+		}
+	}
+
+	cfg.DarkStyle = "no-such-style"
+	if _, err := HighlightCSS(cfg); err == nil {
+		t.Error("want an error for an unknown style")
+	}
+}
+
+func TestShortcodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		wantBody  string
+		wantCalls []site.Shortcode
+		wantErr   string
+	}{
+		{
+			name:     "named arguments",
+			source:   "Intro\n\n{{< figure src=\"a.png\" caption=\"A \\\"quoted\\\" >}} caption\" >}}\n\nAfter",
+			wantBody: "<p>Intro</p>\n<p>@0@</p>\n<p>After</p>\n",
+			wantCalls: []site.Shortcode{
+				{Name: "figure", Params: map[string]string{"src": "a.png", "caption": `A "quoted" >}} caption`}, Line: 3},
+			},
+		},
+		{
+			name:     "positional arguments",
+			source:   "Watch {{< youtube abc123 \"My talk\" />}} now",
+			wantBody: "<p>Watch @0@ now</p>\n",
+			wantCalls: []site.Shortcode{
+				{Name: "youtube", Args: []string{"abc123", "My talk"}, Line: 1},
+			},
+		},
+		{
+			name:     "paired with markdown",
+			source:   "{{< callout type=tip >}}\nUse **bold**.\n{{< /callout >}}\n\nNext",
+			wantBody: "<p>@0@</p>\n<p>Next</p>\n",
+			wantCalls: []site.Shortcode{
+				{Name: "callout", Params: map[string]string{"type": "tip"}, Paired: true, InnerHTML: "Use <strong>bold</strong>.", Line: 1},
+			},
+		},
+		{
+			name:     "nested",
+			source:   "{{< box >}}\n\nA {{< kbd >}}Ctrl{{< /kbd >}}\n\nB\n\n{{< /box >}}",
+			wantBody: "<p>@1@</p>\n",
+			wantCalls: []site.Shortcode{
+				{Name: "kbd", Paired: true, InnerHTML: "Ctrl", Line: 3},
+				{Name: "box", Paired: true, InnerHTML: "<p>A @0@</p>\n<p>B</p>\n", Line: 1},
+			},
+		},
+		{
+			name:     "unpaired opening tag stands alone",
+			source:   "{{< note >}}{{< box >}}x{{< /box >}}",
+			wantBody: "<p>@0@@1@</p>\n",
+			wantCalls: []site.Shortcode{
+				{Name: "note", Line: 1},
+				{Name: "box", Paired: true, InnerHTML: "x", Line: 1},
+			},
+		},
+		{
+			name:     "placeholder lookalikes",
+			source:   "`\uE000shortcode.0.0\uE001` {{< kbd >}}x{{< /kbd >}}",
+			wantBody: "<p><code>\uE000shortcode.0.0\uE001</code> @0@</p>\n",
+			wantCalls: []site.Shortcode{
+				{Name: "kbd", Paired: true, InnerHTML: "x", Line: 1},
+			},
+		},
+		{
+			name:     "escaped",
+			source:   "Write `{{</* figure src=\"a.png\" */>}}`",
+			wantBody: "<p>Write <code>{{&lt; figure src=&quot;a.png&quot; &gt;}}</code></p>\n",
+		},
+		{
+			name:    "unterminated tag",
+			source:  "Text\n\n{{< figure src=\"a.png\"\n",
+			wantErr: "content/post.md:6: unterminated shortcode: no closing >}}",
+		},
+		{
+			name:    "unterminated string",
+			source:  "{{< figure src=\"a.png >}}",
+			wantErr: "content/post.md:4: unterminated shortcode: no closing >}}",
+		},
+		{
+			name:    "mixed arguments",
+			source:  "\n{{< figure a.png caption=\"x\" >}}",
+			wantErr: "content/post.md:5: shortcode figure mixes named and positional arguments",
+		},
+		{
+			name:    "stray closing tag",
+			source:  "{{< /note >}}",
+			wantErr: "content/post.md:4: closing shortcode note without an opening one",
+		},
+		{
+			name:    "bad name",
+			source:  "{{< \"figure\" >}}",
+			wantErr: `content/post.md:4: invalid shortcode name in {{< "figure" >}}`,
+		},
+		{
+			name:    "error in inner markdown",
+			source:  "{{< note >}}\n\n```go {hl_lines=[0]}\nx\n```\n{{< /note >}}",
+			wantErr: "content/post.md:6: hl_lines: 0 is not a line number",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := "---\ntitle: Post\n---\n" + tt.source
+			page, err := Parse("content/post.md", []byte(source))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// @n@ in the expectations stands for the nth placeholder
+			var placeholders []string
+			for i, sc := range page.Shortcodes {
+				if !strings.HasPrefix(sc.Placeholder, "\uE000") {
+					t.Errorf("Shortcodes[%d].Placeholder = %q", i, sc.Placeholder)
+				}
+				placeholders = append(placeholders, fmt.Sprintf("@%d@", i), sc.Placeholder)
+			}
+			replacer := strings.NewReplacer(placeholders...)
+
+			if want := replacer.Replace(tt.wantBody); page.Body != want {
+				t.Errorf("Body = %q, want %q", page.Body, want)
+			}
+			for i := range tt.wantCalls {
+				tt.wantCalls[i].Line += 3
+				tt.wantCalls[i].InnerHTML = replacer.Replace(tt.wantCalls[i].InnerHTML)
+				tt.wantCalls[i].Placeholder = page.Shortcodes[i].Placeholder
+			}
+			if !reflect.DeepEqual(page.Shortcodes, tt.wantCalls) {
+				t.Errorf("Shortcodes = %+v, want %+v", page.Shortcodes, tt.wantCalls)
+			}
+			if page.RawBody != tt.source {
+				t.Errorf("RawBody = %q, want the source", page.RawBody)
+			}
+		})
+	}
+}
+
+func TestExpandShortcodes(t *testing.T) {
+	shortcodes := []site.Shortcode{
+		{Name: "figure", Placeholder: placeholder("k", 0)},
+		{Name: "kbd", Placeholder: placeholder("k", 1)},
+		{Name: "unused", Placeholder: placeholder("k", 2)},
+	}
+	html := "<p>" + placeholder("k", 0) + "</p>\n<p>Press " + placeholder("k", 1) + " or " + placeholder("other", 0) + "</p>\n"
+	outputs := []string{"<figure>F</figure>", "<kbd>K</kbd>"}
+
+	got, err := ExpandShortcodes(html, shortcodes, func(n int) (string, error) {
+		if n >= len(outputs) {
+			t.Errorf("ran shortcode %d, whose placeholder isn't in the HTML", n)
+			return "", nil
+		}
+		return outputs[n], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "<figure>F</figure>\n<p>Press <kbd>K</kbd> or " + placeholder("other", 0) + "</p>\n"; got != want {
+		t.Errorf("ExpandShortcodes() = %q, want %q", got, want)
+	}
+}
+
+func TestRefs(t *testing.T) {
+	src := "---\ntitle: Refs\n---\n## Why\n\nSee [about](../about.md#why), [post](post.MD), [site](https://example.com/a.md) and [why](#why).\n"
+	page, err := Parse("content/blog/refs.md", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"why"}; !reflect.DeepEqual(page.Anchors, want) {
+		t.Errorf("Anchors = %v, want %v", page.Anchors, want)
+	}
+
+	var targets []string
+	got := ExpandRefs(page.Body, func(target string) string {
+		targets = append(targets, target)
+		return "/resolved/"
+	})
+	if want := []string{"../about.md#why", "post.MD"}; !reflect.DeepEqual(targets, want) {
+		t.Errorf("targets = %v, want %v", targets, want)
+	}
+	for _, want := range []string{
+		`<a href="/resolved/">about</a>`,
+		`<a href="/resolved/">post</a>`,
+		`<a href="https://example.com/a.md">site</a>`,
+		`<a href="#why">why</a>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("body = %s, want it to contain %s", got, want)
+		}
+	}
+
+	// Text shaped like the old ASCII placeholders is left alone
+	lookalikes := "Hex XREF6162X and `XREF6162X` and [x](XREF6162X).\n"
+	page, err = Parse("content/blog/hex.md", []byte(lookalikes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := ExpandRefs(page.Body, func(target string) string {
+		t.Errorf("resolved %q", target)
+		return target
+	}); got != page.Body || strings.Count(got, "XREF6162X") != 3 {
+		t.Errorf("body = %s, want the lookalikes untouched", got)
+	}
+}
+
+// testHooks renders elements with fmt-style formats of their context
+type testHooks map[string]func(ctx *site.HookContext) (string, error)
+
+func (h testHooks) HasHook(kind string) bool { return h[kind] != nil }
+
+func (h testHooks) RenderHook(kind string, ctx *site.HookContext) (string, error) {
+	return h[kind](ctx)
+}
+
+func TestRenderHooks(t *testing.T) {
+	hooks := testHooks{
+		HookLink: func(ctx *site.HookContext) (string, error) {
+			return fmt.Sprintf("[link %s %q %s|%s]", ctx.Destination, ctx.Title, ctx.Text, ctx.PlainText), nil
+		},
+		HookImage: func(ctx *site.HookContext) (string, error) {
+			return fmt.Sprintf("[image %s %s in %s]", ctx.Destination, ctx.PlainText, ctx.Path), nil
+		},
+		HookHeading: func(ctx *site.HookContext) (string, error) {
+			return fmt.Sprintf("[h%d #%s %s]", ctx.Level, ctx.Anchor, ctx.Text), nil
+		},
+		HookCodeBlock: func(ctx *site.HookContext) (string, error) {
+			if ctx.Lang == "fail" {
+				return "", fmt.Errorf("unsupported")
+			}
+			return fmt.Sprintf("[code %s %q %t]", ctx.Lang, ctx.Code, strings.Contains(ctx.Text, `class="chroma"`)), nil
+		},
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"link", `[Go **docs**](https://go.dev "Go")`, `<p>[link https://go.dev "Go" Go <strong>docs</strong>|Go docs]</p>`},
+		{"autolink", "see https://go.dev", `<p>see [link https://go.dev "" https://go.dev|https://go.dev]</p>`},
+		{"image", `![A cat](cat.png)`, `<p>[image cat.png A cat in content/post.md]</p>`},
+		{"heading", "## Why *Go*?", `[h2 #why-go Why <em>Go</em>?]`},
+		{"code block", "```go {hl_lines=[1]}\nx := 1\n```", `[code go "x := 1\n" true]`},
+	}
+
+	p := New(site.DefaultConfig().Markup)
+	p.SetHooks(hooks)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := p.Parse("content/post.md", []byte(tt.source))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(page.Body); got != tt.want {
+				t.Errorf("Body = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("heading ids still listed", func(t *testing.T) {
+		page, err := p.Parse("content/post.md", []byte("## One\n\n## Two"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.TableOfContents) != 2 || page.TableOfContents[1].ID != "two" {
+			t.Errorf("TableOfContents = %+v", page.TableOfContents)
+		}
+	})
+
+	t.Run("errors name the line", func(t *testing.T) {
+		source := "---\ntitle: Post\n---\nText\n\n```fail\nx\n```"
+		_, err := p.Parse("content/post.md", []byte(source))
+		if want := "content/post.md:6: unsupported"; err == nil || err.Error() != want {
+			t.Errorf("err = %v, want %s", err, want)
+		}
+	})
+
+	t.Run("elements without hooks", func(t *testing.T) {
+		q := New(site.DefaultConfig().Markup)
+		q.SetHooks(testHooks{HookImage: hooks[HookImage]})
+		page, err := q.Parse("content/post.md", []byte("## Title\n\n[a](/b)"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []string{`<a href="#title" class="heading-anchor"`, `<a href="/b">a</a>`} {
+			if !strings.Contains(page.Body, want) {
+				t.Errorf("Body = %s, want it to contain %s", page.Body, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package parser
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Links to markdown files, as in [see](../about.md#why), point at the
+// permalink of the target page, which isn't known until every file is
+// parsed. Their destinations are replaced by placeholders holding the
+// hex-encoded target, and the builder resolves them afterwards. Like
+// shortcode placeholders they are wrapped in private-use runes, so page
+// text can't pass for one; the runes are also matched percent-encoded, as
+// they are once in an href.
+var refPattern = regexp.MustCompile(`(?:\x{E000}|(?i:%EE%80%80))ref\.([0-9a-f]+)(?:\x{E001}|(?i:%EE%80%81))`)
+
+func refPlaceholder(target string) string {
+	return fmt.Sprintf("\uE000ref.%x\uE001", target)
+}
+
+// isRef reports whether a link destination is a markdown file on this
+// site rather than a URL
+func isRef(dest string) bool {
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.RawQuery != "" {
+		return false
+	}
+	return strings.HasSuffix(strings.ToLower(u.Path), ".md")
+}
+
+// ExpandRefs replaces the link placeholders in html with resolve's URL
+// for each target, such as "../about.md#why"
+func ExpandRefs(html string, resolve func(target string) string) string {
+	return refPattern.ReplaceAllStringFunc(html, func(match string) string {
+		target, err := hex.DecodeString(refPattern.FindStringSubmatch(match)[1])
+		if err != nil {
+			return match
+		}
+		return resolve(string(target))
+	})
+}
@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+var bom = []byte("\xef\xbb\xbf")
+
+// frontMatterBlock is a source file split into front matter and body
+type frontMatterBlock struct {
+	format *frontMatterFormat // nil when the file has no front matter
+	raw    []byte             // front matter without its delimiter lines
+	line   int                // file line raw starts on
+	body   []byte             // everything after the front matter, a suffix of the file
+}
+
+// splitFrontMatter finds the front matter at the start of data, line by
+// line. Delimiters must be alone on their line; a UTF-8 byte order mark
+// and CRLF line endings are accepted.
+func splitFrontMatter(data []byte) (frontMatterBlock, error) {
+	content := bytes.TrimPrefix(data, bom)
+
+	format := detectFormat(string(content))
+	if format == nil {
+		return frontMatterBlock{body: content}, nil
+	}
+
+	// JSON front matter is the object the file starts with; the body
+	// starts on the line after its closing brace
+	if format.delimiter == "" {
+		var raw json.RawMessage
+		dec := json.NewDecoder(bytes.NewReader(content))
+		if err := dec.Decode(&raw); err != nil {
+			return frontMatterBlock{}, jsonError(string(content), err)
+		}
+
+		end := int(dec.InputOffset())
+		rest := content[end:]
+		if line, next, ok := cutLine(rest); ok && len(bytes.TrimSpace(line)) == 0 {
+			rest = next
+		}
+		return frontMatterBlock{format: format, raw: content[:end], line: 1, body: rest}, nil
+	}
+
+	// The opening delimiter must be the whole first line
+	first, rest, _ := cutLine(content)
+	if !isDelimiter(first, format.delimiter) {
+		return frontMatterBlock{body: content}, nil
+	}
+
+	for offset := 0; offset < len(rest); {
+		line, next, _ := cutLine(rest[offset:])
+		if isDelimiter(line, format.delimiter) {
+			return frontMatterBlock{
+				format: format,
+				raw:    rest[:offset],
+				line:   2,
+				body:   next,
+			}, nil
+		}
+		offset = len(rest) - len(next)
+	}
+
+	return frontMatterBlock{}, &Error{
+		Line: 1,
+		Msg:  fmt.Sprintf("unterminated front matter: no closing %s line", format.delimiter),
+	}
+}
+
+// cutLine splits data after its first line. line excludes the line
+// ending; ok reports whether a newline was found.
+func cutLine(data []byte) (line, rest []byte, ok bool) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return bytes.TrimSuffix(data, []byte("\r")), nil, false
+	}
+	return bytes.TrimSuffix(data[:i], []byte("\r")), data[i+1:], true
+}
+
+func isDelimiter(line []byte, delimiter string) bool {
+	return string(bytes.TrimRight(line, " \t")) == delimiter
+}
@@ -0,0 +1,378 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sporollan/site/internal/site"
+)
+
+// Shortcode calls are replaced by placeholders while markdown is
+// rendered, so their output isn't treated as markdown or stripped as raw
+// HTML. The builder swaps the placeholders for the output once every page
+// is known. Placeholders are wrapped in private-use runes and carry a hash
+// of the source, so text written in a page, in code or prose, can't pass
+// for one.
+func placeholder(key string, n int) string {
+	return fmt.Sprintf("\uE000shortcode.%s.%d\uE001", key, n)
+}
+
+var shortcodeName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// shortcodeTag is an opening or closing tag in markdown:
+//
+//	{{< figure src="a.png" caption="A" >}}
+//	{{< note >}}**Markdown** inside{{< /note >}}
+//	{{< youtube abc123 />}}
+//
+// {{</* name */>}} is written out as {{< name >}} without running
+// anything, for documenting shortcodes.
+type shortcodeTag struct {
+	start, end  int // offsets of "{{<" and just past ">}}"
+	line        int
+	name        string
+	closing     bool
+	selfClosing bool
+	args        []string
+	params      map[string]string
+	literal     string // text of an escaped tag
+}
+
+// scanShortcodes finds every shortcode tag in src
+func scanShortcodes(src string) ([]shortcodeTag, error) {
+	var tags []shortcodeTag
+
+	for offset := 0; ; {
+		i := strings.Index(src[offset:], "{{<")
+		if i < 0 {
+			return tags, nil
+		}
+		start := offset + i
+		line := strings.Count(src[:start], "\n") + 1
+
+		if strings.HasPrefix(src[start:], "{{</*") {
+			end := strings.Index(src[start:], "*/>}}")
+			if end < 0 {
+				return nil, &Error{Line: line, Msg: "unterminated shortcode: no closing */>}}"}
+			}
+			tags = append(tags, shortcodeTag{
+				start:   start,
+				end:     start + end + len("*/>}}"),
+				line:    line,
+				literal: "{{<" + src[start+len("{{</*"):start+end] + ">}}",
+			})
+			offset = start + end + len("*/>}}")
+			continue
+		}
+
+		tag, err := parseShortcodeTag(src, start)
+		if err != nil {
+			return nil, &Error{Line: line, Msg: err.Error()}
+		}
+		tag.line = line
+		tags = append(tags, tag)
+		offset = tag.end
+	}
+}
+
+// parseShortcodeTag reads the tag starting at src[start:]. Quoted
+// arguments may contain ">}}".
+func parseShortcodeTag(src string, start int) (shortcodeTag, error) {
+	tag := shortcodeTag{start: start}
+
+	inside := ""
+	quoted := false
+	for i := start + len("{{<"); i < len(src); i++ {
+		switch {
+		case quoted && src[i] == '\\':
+			i++
+		case src[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(src[i:], ">}}"):
+			inside = src[start+len("{{<") : i]
+			tag.end = i + len(">}}")
+		}
+		if tag.end > 0 {
+			break
+		}
+	}
+	if tag.end == 0 {
+		return tag, fmt.Errorf("unterminated shortcode: no closing >}}")
+	}
+
+	inside = strings.TrimSpace(inside)
+	if strings.HasSuffix(inside, "/") {
+		tag.selfClosing = true
+		inside = strings.TrimSpace(strings.TrimSuffix(inside, "/"))
+	}
+	if strings.HasPrefix(inside, "/") {
+		tag.closing = true
+		inside = strings.TrimSpace(strings.TrimPrefix(inside, "/"))
+	}
+
+	words, err := shortcodeFields(inside)
+	if err != nil {
+		return tag, err
+	}
+	if len(words) == 0 || words[0].key != "" || words[0].quoted || !shortcodeName.MatchString(words[0].value) {
+		return tag, fmt.Errorf("invalid shortcode name in %s", src[start:tag.end])
+	}
+	tag.name = words[0].value
+
+	if tag.closing {
+		if len(words) > 1 || tag.selfClosing {
+			return tag, fmt.Errorf("closing shortcode %s takes no arguments", tag.name)
+		}
+		return tag, nil
+	}
+
+	for _, word := range words[1:] {
+		if word.key == "" {
+			tag.args = append(tag.args, word.value)
+			continue
+		}
+		if tag.params == nil {
+			tag.params = make(map[string]string)
+		}
+		if _, dup := tag.params[word.key]; dup {
+			return tag, fmt.Errorf("shortcode %s: argument %s is set twice", tag.name, word.key)
+		}
+		tag.params[word.key] = word.value
+	}
+	if len(tag.args) > 0 && len(tag.params) > 0 {
+		return tag, fmt.Errorf("shortcode %s mixes named and positional arguments", tag.name)
+	}
+
+	return tag, nil
+}
+
+// shortcodeWord is a positional argument, or a named one when key is set
+type shortcodeWord struct {
+	key    string
+	value  string
+	quoted bool
+}
+
+// shortcodeFields splits the inside of a tag into words. Values are bare
+// words or double-quoted strings with Go escapes.
+func shortcodeFields(s string) ([]shortcodeWord, error) {
+	var words []shortcodeWord
+
+	for i := 0; ; {
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i == len(s) {
+			return words, nil
+		}
+
+		var word shortcodeWord
+		if s[i] != '"' {
+			end := i
+			for end < len(s) && !isSpace(s[end]) && s[end] != '=' && s[end] != '"' {
+				end++
+			}
+			word.value = s[i:end]
+			i = end
+			if i < len(s) && s[i] == '=' {
+				if word.value == "" {
+					return nil, fmt.Errorf("argument without a name in %q", s)
+				}
+				word.key = word.value
+				word.value = ""
+				i++
+			}
+		}
+
+		if word.key == "" && word.value != "" {
+			words = append(words, word)
+			continue
+		}
+
+		// A quoted value, or the value of a named argument
+		if i < len(s) && s[i] == '"' {
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string in %q", s)
+			}
+			value, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", s[i:end+1])
+			}
+			word.value = value
+			word.quoted = true
+			i = end + 1
+		} else {
+			end := i
+			for end < len(s) && !isSpace(s[end]) {
+				end++
+			}
+			if end == i {
+				return nil, fmt.Errorf("argument %s has no value", word.key)
+			}
+			word.value = s[i:end]
+			i = end
+		}
+		words = append(words, word)
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// shortcodeExtractor replaces shortcode calls with placeholders
+type shortcodeExtractor struct {
+	p          *Parser
+	path       string
+	src        string
+	key        string // hash of src, for placeholders
+	tags       []shortcodeTag
+	pairs      map[int]int // index of an opening tag -> index of its closing tag
+	shortcodes []site.Shortcode
+}
+
+// extractShortcodes replaces the shortcode calls in markdown with
+// placeholders, followed by as many line breaks as each call spanned so
+// later lines keep their numbers. The markdown between paired tags is
+// rendered into the shortcode's InnerHTML. Lines count from the start of
+// src.
+func (p *Parser) extractShortcodes(path, src string) (string, []site.Shortcode, error) {
+	tags, err := scanShortcodes(src)
+	if err != nil || len(tags) == 0 {
+		return src, nil, err
+	}
+
+	// A closing tag pairs with the closest opening tag of the same name;
+	// opening tags it skips over stand alone
+	pairs := make(map[int]int)
+	var open []int
+	for i, tag := range tags {
+		switch {
+		case tag.literal != "" || tag.selfClosing:
+		case !tag.closing:
+			open = append(open, i)
+		default:
+			j := len(open) - 1
+			for j >= 0 && tags[open[j]].name != tag.name {
+				j--
+			}
+			if j < 0 {
+				return "", nil, &Error{Line: tag.line, Msg: fmt.Sprintf("closing shortcode %s without an opening one", tag.name)}
+			}
+			pairs[open[j]] = i
+			open = open[:j]
+		}
+	}
+
+	sum := sha256.Sum256([]byte(src))
+	e := &shortcodeExtractor{p: p, path: path, src: src, key: hex.EncodeToString(sum[:6]), tags: tags, pairs: pairs}
+	out, err := e.text(0, len(src), 0, len(tags))
+	if err != nil {
+		return "", nil, err
+	}
+	return out, e.shortcodes, nil
+}
+
+// text rewrites src[from:to], which holds the tags from first up to last
+func (e *shortcodeExtractor) text(from, to, first, last int) (string, error) {
+	var b strings.Builder
+	pos := from
+
+	for i := first; i < last; i++ {
+		tag := e.tags[i]
+		b.WriteString(e.src[pos:tag.start])
+		pos = tag.end
+
+		if tag.literal != "" {
+			b.WriteString(tag.literal)
+			continue
+		}
+
+		sc := site.Shortcode{
+			Name:   tag.name,
+			Args:   tag.args,
+			Params: tag.params,
+			Line:   tag.line,
+		}
+
+		if closing, ok := e.pairs[i]; ok {
+			inner, err := e.text(tag.end, e.tags[closing].start, i+1, closing)
+			if err != nil {
+				return "", err
+			}
+			html, _, err := e.p.render(e.path, []byte(inner))
+			if err != nil {
+				if re, ok := err.(*Error); ok {
+					re.Line += strings.Count(e.src[:tag.end], "\n")
+				}
+				return "", err
+			}
+			sc.InnerHTML = unwrapParagraph(html)
+			sc.Paired = true
+
+			pos = e.tags[closing].end
+			i = closing
+		}
+
+		sc.Placeholder = placeholder(e.key, len(e.shortcodes))
+		b.WriteString(sc.Placeholder)
+		b.WriteString(strings.Repeat("\n", strings.Count(e.src[tag.start:pos], "\n")))
+		e.shortcodes = append(e.shortcodes, sc)
+	}
+
+	b.WriteString(e.src[pos:to])
+	return b.String(), nil
+}
+
+// unwrapParagraph strips the paragraph around HTML that is a single
+// paragraph, so paired shortcodes can wrap inline text
+func unwrapParagraph(html string) string {
+	trimmed := strings.TrimSpace(html)
+	if strings.HasPrefix(trimmed, "<p>") && strings.HasSuffix(trimmed, "</p>") && strings.Count(trimmed, "<p>") == 1 {
+		return strings.TrimSuffix(strings.TrimPrefix(trimmed, "<p>"), "</p>")
+	}
+	return html
+}
+
+// HasShortcodes reports whether s holds placeholders of shortcodes
+func HasShortcodes(s string, shortcodes []site.Shortcode) bool {
+	for _, sc := range shortcodes {
+		if sc.Placeholder != "" && strings.Contains(s, sc.Placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpandShortcodes replaces the placeholders of shortcodes in html with
+// the output of run for the shortcode at that index. A placeholder alone
+// in a paragraph replaces the whole paragraph. Shortcodes whose
+// placeholder isn't in html aren't run.
+func ExpandShortcodes(html string, shortcodes []site.Shortcode, run func(n int) (string, error)) (string, error) {
+	var pairs []string
+	for n, sc := range shortcodes {
+		if sc.Placeholder == "" || !strings.Contains(html, sc.Placeholder) {
+			continue
+		}
+		output, err := run(n)
+		if err != nil {
+			return "", err
+		}
+		pairs = append(pairs, "<p>"+sc.Placeholder+"</p>", output, sc.Placeholder, output)
+	}
+	if len(pairs) == 0 {
+		return html, nil
+	}
+	return strings.NewReplacer(pairs...).Replace(html), nil
+}
@@ -0,0 +1,152 @@
+package parser
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MoreSeparator splits the summary from the rest of a page's markdown
+const MoreSeparator = "<!--more-->"
+
+// Ellipsis is appended to summaries that were cut short
+const Ellipsis = "…"
+
+// blockTags separate words even when no whitespace surrounds them
+var blockTags = map[string]bool{
+	"p": true, "div": true, "br": true, "hr": true, "li": true, "ul": true, "ol": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"blockquote": true, "pre": true, "table": true, "tr": true, "td": true, "th": true,
+	"dl": true, "dt": true, "dd": true, "figure": true, "figcaption": true, "section": true,
+}
+
+// voidTags never have a closing tag
+var voidTags = map[string]bool{
+	"br": true, "hr": true, "img": true, "input": true, "meta": true, "link": true,
+	"source": true, "wbr": true, "col": true, "area": true, "embed": true,
+}
+
+// Summarize returns the text of rendered HTML, cut on a word boundary
+// after at most limit characters, together with the matching HTML with
+// every tag left open by the cut closed again. A limit of 0 or less keeps
+// the whole text.
+func Summarize(body string, limit int) (text, summaryHTML string, truncated bool) {
+	var plain, out strings.Builder
+	var open []string
+	count := 0
+	space := false // a separator is due before the next word
+	ws := ""       // whitespace not yet copied to the HTML
+
+	for i := 0; i < len(body) && !truncated; {
+		// Tags are copied as they are, keeping track of which are open
+		if body[i] == '<' {
+			end := strings.IndexByte(body[i:], '>')
+			if end < 0 {
+				break
+			}
+			tag := body[i : i+end+1]
+			i += end + 1
+
+			if strings.HasPrefix(tag, "<!") {
+				continue
+			}
+
+			name, closing := tagName(tag)
+			if blockTags[name] {
+				space = true
+			}
+
+			switch {
+			case closing:
+				if n := len(open); n > 0 && open[n-1] == name {
+					open = open[:n-1]
+				}
+			case !voidTags[name] && !strings.HasSuffix(tag, "/>"):
+				open = append(open, name)
+			}
+			out.WriteString(ws + tag)
+			ws = ""
+			continue
+		}
+
+		end := strings.IndexByte(body[i:], '<')
+		if end < 0 {
+			end = len(body) - i
+		}
+		raw := html.UnescapeString(body[i : i+end])
+		i += end
+
+		trimmed := strings.TrimLeftFunc(raw, unicode.IsSpace)
+		if lead := raw[:len(raw)-len(trimmed)]; lead != "" {
+			space = true
+			ws += lead
+		}
+		if trimmed == "" {
+			continue
+		}
+
+		for j, word := range strings.Fields(trimmed) {
+			sep := ""
+			if (space || j > 0) && count > 0 {
+				sep = " "
+			}
+			space = false
+
+			outSep := ws
+			if j > 0 {
+				outSep = " "
+			}
+			ws = ""
+
+			length := utf8.RuneCountInString(word)
+			if limit > 0 && count+len(sep)+length > limit {
+				// A single word longer than the limit is cut mid-word
+				if count == 0 {
+					word = string([]rune(word)[:limit])
+					plain.WriteString(word)
+					out.WriteString(outSep + html.EscapeString(word))
+				}
+				truncated = true
+				break
+			}
+
+			plain.WriteString(sep + word)
+			out.WriteString(outSep + html.EscapeString(word))
+			count += len(sep) + length
+		}
+
+		if trail := trimmed[len(strings.TrimRightFunc(trimmed, unicode.IsSpace)):]; trail != "" {
+			space = true
+			ws = trail
+		}
+	}
+
+	if !truncated {
+		return plain.String(), body, false
+	}
+
+	out.WriteString(Ellipsis)
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+
+	return plain.String() + Ellipsis, out.String(), true
+}
+
+// tagName returns the lower-cased element name of an HTML tag and whether
+// it is a closing tag
+func tagName(tag string) (string, bool) {
+	tag = strings.TrimPrefix(tag, "<")
+	closing := strings.HasPrefix(tag, "/")
+	tag = strings.TrimPrefix(tag, "/")
+
+	end := strings.IndexFunc(tag, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '>' || r == '/'
+	})
+	if end < 0 {
+		end = len(tag)
+	}
+
+	return strings.ToLower(tag[:end]), closing
+}
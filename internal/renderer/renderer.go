@@ -4,33 +4,96 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
+	"log"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sporollan/site/internal/site"
 )
 
 type Renderer struct {
-	templates *template.Template
+	templates  *template.Template
+	shortcodes *template.Template
+	hooks      *template.Template
+
+	mu     sync.RWMutex
+	assets map[string]*site.Asset
+}
+
+var funcs = template.FuncMap{
+	"now": func() time.Time { return time.Now() },
+	"safeHTML": func(s string) template.HTML {
+		return template.HTML(s)
+	},
+	"first": func(n int, pages []*site.Page) []*site.Page {
+		if n > len(pages) {
+			n = len(pages)
+		}
+		return pages[:n]
+	},
+	// errorf fails the template, such as a shortcode called with bad arguments
+	"errorf": func(format string, args ...interface{}) (string, error) {
+		return "", fmt.Errorf(format, args...)
+	},
+	// relref and ref link to a content file, as in {{relref . "blog/post.md"}};
+	// ref includes the base URL
+	"relref": func(from interface{}, target string) (string, error) {
+		return ref(from, target, false)
+	},
+	"ref": func(from interface{}, target string) (string, error) {
+		return ref(from, target, true)
+	},
+}
+
+// ref resolves target with Site.Ref from the page or shortcode context
+// a template runs with. Broken references fail the template unless
+// markup.brokenRefs is "warning", which leaves them as written.
+func ref(from interface{}, target string, absolute bool) (string, error) {
+	var page *site.Page
+	switch from := from.(type) {
+	case site.Page:
+		page = &from
+	case *site.Page:
+		page = from
+	case *ShortcodeContext:
+		page = from.Page
+	}
+	if page == nil || page.Site == nil {
+		return "", fmt.Errorf("ref %s: first argument must be a page", target)
+	}
+
+	link, err := page.Site.Ref(page.Path, target)
+	if err != nil {
+		if page.Site.Config.Markup.BrokenRefs == "warning" {
+			log.Printf("Warning: %s: broken ref %s: %v", page.Path, target, err)
+			return target, nil
+		}
+		return "", fmt.Errorf("broken ref %s: %w", target, err)
+	}
+	if absolute {
+		link = page.Site.BaseURL + link
+	}
+	return link, nil
 }
 
 func New(templateDir string) (*Renderer, error) {
+	r := &Renderer{}
+
+	// asset looks up the files processed by the current build. Shortcodes
+	// and render hooks run before assets are processed, so only page
+	// templates get it.
+	fm := template.FuncMap{"asset": r.asset}
+	for name, fn := range funcs {
+		fm[name] = fn
+	}
+
 	// Parse all templates with a common name
 	pattern := filepath.Join(templateDir, "*.html")
 
 	// Create a template with functions first
-	tmpl := template.New("").Funcs(template.FuncMap{
-		"now": func() time.Time { return time.Now() },
-		"safeHTML": func(s string) template.HTML {
-			return template.HTML(s)
-		},
-		"first": func(n int, pages []*site.Page) []*site.Page {
-			if n > len(pages) {
-				n = len(pages)
-			}
-			return pages[:n]
-		},
-	})
+	tmpl := template.New("").Funcs(fm)
 
 	// Parse all template files
 	tmpl, err := tmpl.ParseGlob(pattern)
@@ -38,13 +101,55 @@ func New(templateDir string) (*Renderer, error) {
 		return nil, fmt.Errorf("failed to parse templates: %w", err)
 	}
 
+	// Shortcodes and render hooks are separate sets, named after their files
+	shortcodes, err := parseDir(filepath.Join(templateDir, "shortcodes"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse shortcodes: %w", err)
+	}
+	hooks, err := parseDir(filepath.Join(templateDir, "_hooks"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse render hooks: %w", err)
+	}
+
 	// Debug: list all templates
 	fmt.Printf("Loaded %d templates:\n", len(tmpl.Templates()))
 	for _, t := range tmpl.Templates() {
 		fmt.Printf("  - %s\n", t.Name())
 	}
 
-	return &Renderer{templates: tmpl}, nil
+	r.templates, r.shortcodes, r.hooks = tmpl, shortcodes, hooks
+	return r, nil
+}
+
+// parseDir parses the templates in dir, which may not exist
+func parseDir(dir string) (*template.Template, error) {
+	tmpl := template.New("").Funcs(funcs)
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil || len(files) == 0 {
+		return tmpl, err
+	}
+	return tmpl.ParseFiles(files...)
+}
+
+// SetAssets sets the processed CSS and JS files templates link to with
+// {{asset "css/style.css"}}
+func (r *Renderer) SetAssets(assets map[string]*site.Asset) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.assets = assets
+}
+
+// asset returns the processed file built from the static or generated
+// file at name, with its fingerprinted URL and integrity hash
+func (r *Renderer) asset(name string) (*site.Asset, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	asset, ok := r.assets[strings.TrimPrefix(name, "/")]
+	if !ok {
+		return nil, fmt.Errorf("unknown asset %q: not a CSS or JS file in static/ or a bundle", name)
+	}
+	return asset, nil
 }
 
 func (r *Renderer) Render(p site.Page) ([]byte, error) {
@@ -76,3 +181,72 @@ func (r *Renderer) Render(p site.Page) ([]byte, error) {
 
 	return buf.Bytes(), nil
 }
+
+// ShortcodeContext is the data a shortcode template executes with
+type ShortcodeContext struct {
+	Name   string
+	Args   []string          // positional arguments
+	Params map[string]string // named arguments
+	Inner  template.HTML     // content between paired tags, rendered from markdown
+	Page   *site.Page
+	Site   *site.Site
+}
+
+// Get returns the positional argument at an index or the named argument
+// with a name, or "" if there is none
+func (c *ShortcodeContext) Get(key interface{}) string {
+	switch key := key.(type) {
+	case int:
+		if key >= 0 && key < len(c.Args) {
+			return c.Args[key]
+		}
+	case string:
+		return c.Params[key]
+	}
+	return ""
+}
+
+// RenderShortcode executes the template of a shortcode called from page.
+// inner is the shortcode's InnerHTML with nested shortcodes already run.
+func (r *Renderer) RenderShortcode(sc *site.Shortcode, inner string, page *site.Page) (string, error) {
+	tmpl := r.shortcodes.Lookup(sc.Name + ".html")
+	if tmpl == nil {
+		return "", fmt.Errorf("unknown shortcode %q: no template shortcodes/%s.html", sc.Name, sc.Name)
+	}
+
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, &ShortcodeContext{
+		Name:   sc.Name,
+		Args:   sc.Args,
+		Params: sc.Params,
+		Inner:  template.HTML(inner),
+		Page:   page,
+		Site:   page.Site,
+	})
+	if err != nil {
+		return "", fmt.Errorf("shortcode %s: %w", sc.Name, err)
+	}
+
+	return buf.String(), nil
+}
+
+// HasHook reports whether templates/_hooks/ has a template for kind
+func (r *Renderer) HasHook(kind string) bool {
+	return r.hooks.Lookup(kind+".html") != nil
+}
+
+// RenderHook executes the render hook for kind. Surrounding whitespace is
+// dropped so hooks for inline elements don't add spaces.
+func (r *Renderer) RenderHook(kind string, ctx *site.HookContext) (string, error) {
+	tmpl := r.hooks.Lookup(kind + ".html")
+	if tmpl == nil {
+		return "", fmt.Errorf("no %s render hook", kind)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ctx); err != nil {
+		return "", fmt.Errorf("%s render hook: %w", kind, err)
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
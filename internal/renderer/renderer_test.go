@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -71,6 +72,26 @@ func TestNew(t *testing.T) {
 		}
 	})
 
+	t.Run("asset only in page templates", func(t *testing.T) {
+		tmpDir := setupTestTemplates(t)
+		if err := os.WriteFile(filepath.Join(tmpDir, "base.html"), []byte(`{{with asset "css/style.css"}}{{.URL}}{{end}}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(tmpDir); err != nil {
+			t.Fatalf("New() error = %v, want nil", err)
+		}
+
+		if err := os.MkdirAll(filepath.Join(tmpDir, "shortcodes"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, "shortcodes", "css.html"), []byte(`{{asset "css/style.css"}}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(tmpDir); err == nil || !strings.Contains(err.Error(), `"asset" not defined`) {
+			t.Errorf("New() error = %v, want asset undefined in shortcodes", err)
+		}
+	})
+
 	t.Run("missing template directory", func(t *testing.T) {
 		_, err := New("/non/existent/directory")
 		if err == nil {
@@ -149,3 +170,74 @@ func TestRender(t *testing.T) {
 		})
 	}
 }
+
+func TestRenderShortcode(t *testing.T) {
+	tmpDir := setupTestTemplates(t)
+	shortcodes := map[string]string{
+		"figure.html": `{{if not (.Get "src")}}{{errorf "figure needs a src"}}{{end}}<figure><img src="{{.Get "src"}}"><figcaption>{{.Get "caption"}}</figcaption></figure>`,
+		"note.html":   `<aside title="{{.Page.Title}} on {{.Site.SiteName}}">{{.Get 0}}: {{.Inner}}</aside>`,
+	}
+	if err := os.MkdirAll(filepath.Join(tmpDir, "shortcodes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range shortcodes {
+		if err := os.WriteFile(filepath.Join(tmpDir, "shortcodes", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := New(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create renderer: %v", err)
+	}
+
+	page := &site.Page{Title: "Post", Site: &site.Site{SiteName: "Test Site"}}
+
+	tests := []struct {
+		name    string
+		sc      site.Shortcode
+		inner   string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "named arguments",
+			sc:   site.Shortcode{Name: "figure", Params: map[string]string{"src": "a.png", "caption": "<A>"}},
+			want: `<figure><img src="a.png"><figcaption>&lt;A&gt;</figcaption></figure>`,
+		},
+		{
+			name:  "inner html and page context",
+			sc:    site.Shortcode{Name: "note", Args: []string{"Tip"}, Paired: true},
+			inner: "<strong>bold</strong>",
+			want:  `<aside title="Post on Test Site">Tip: <strong>bold</strong></aside>`,
+		},
+		{
+			name:    "bad arguments",
+			sc:      site.Shortcode{Name: "figure"},
+			wantErr: "figure needs a src",
+		},
+		{
+			name:    "unknown shortcode",
+			sc:      site.Shortcode{Name: "missing"},
+			wantErr: `unknown shortcode "missing"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.RenderShortcode(&tt.sc, tt.inner, page)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("RenderShortcode() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("RenderShortcode() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
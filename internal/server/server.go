@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reloadPath is the Server-Sent Events endpoint browsers listen on
+const reloadPath = "/__livereload"
+
+// reloadScript is injected into every served HTML page. It never ends up
+// in the generated files, so production output is unaffected.
+const reloadScript = `<script>new EventSource("` + reloadPath + `").onmessage = function () { location.reload(); };</script>`
+
+// Server serves the output directory, rebuilds the site when its sources
+// change and tells connected browsers to reload.
+type Server struct {
+	dir      string
+	build    func() error
+	watch    []string
+	interval time.Duration
+
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+}
+
+// New creates a server for the files in dir. build is called whenever a
+// file under one of the watch directories changes.
+func New(dir string, build func() error, watch []string) *Server {
+	return &Server{
+		dir:      dir,
+		build:    build,
+		watch:    watch,
+		interval: 500 * time.Millisecond,
+		clients:  make(map[chan struct{}]struct{}),
+	}
+}
+
+// ListenAndServe serves on addr and watches for changes until ctx is done
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s.Handler()}
+
+	go s.Watch(ctx)
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	log.Printf("Serving %s on http://localhost%s", s.dir, addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Handler serves the output directory and the live reload endpoint
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(reloadPath, s.serveEvents)
+	mux.HandleFunc("/", s.serveFile)
+	return mux
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request) {
+	path := filepath.Join(s.dir, filepath.FromSlash(filepath.Clean("/"+r.URL.Path)))
+
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		path = filepath.Join(path, "index.html")
+	}
+
+	if !strings.HasSuffix(path, ".html") {
+		http.ServeFile(w, r, path)
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(injectReload(data))
+}
+
+// injectReload adds the reload script before </body>, or at the end of
+// documents without one.
+func injectReload(html []byte) []byte {
+	i := bytes.LastIndex(html, []byte("</body>"))
+	if i < 0 {
+		return append(html, reloadScript...)
+	}
+
+	out := make([]byte, 0, len(html)+len(reloadScript))
+	out = append(out, html[:i]...)
+	out = append(out, reloadScript...)
+	return append(out, html[i:]...)
+}
+
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.clients[ch] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, ch)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ch:
+			fmt.Fprint(w, "data: reload\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// Reload notifies every connected browser
+func (s *Server) Reload() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.clients {
+		// Clients with a pending reload don't need another one
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Watch polls the watched directories and rebuilds on every change until
+// ctx is done. Build errors are logged so the server keeps running.
+func (s *Server) Watch(ctx context.Context) {
+	last := s.snapshot()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := s.snapshot()
+		if sameSnapshot(last, current) {
+			continue
+		}
+		last = current
+
+		log.Printf("Change detected, rebuilding")
+		if err := s.build(); err != nil {
+			log.Printf("Build failed: %v", err)
+			continue
+		}
+		s.Reload()
+	}
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// snapshot records the modification time and size of every watched file
+func (s *Server) snapshot() map[string]fileState {
+	files := make(map[string]fileState)
+	for _, dir := range s.watch {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			files[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+			return nil
+		})
+	}
+	return files
+}
+
+func sameSnapshot(a, b map[string]fileState) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, state := range a {
+		if b[path] != state {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func setupTestOutput(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":      "<!DOCTYPE html><html><body><h1>Home</h1></body></html>",
+		"blog/index.html": "<!DOCTYPE html><html><body><h1>Blog</h1></body></html>",
+		"css/style.css":   "body { color: red; }",
+		"fragment.html":   "<p>No body tag</p>",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestHandler(t *testing.T) {
+	dir := setupTestOutput(t)
+	ts := httptest.NewServer(New(dir, func() error { return nil }, nil).Handler())
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		contains   []string
+		excludes   []string
+	}{
+		{
+			name:       "root index gets reload script",
+			path:       "/",
+			wantStatus: http.StatusOK,
+			contains:   []string{"<h1>Home</h1>", reloadScript + "</body>"},
+		},
+		{
+			name:       "section index",
+			path:       "/blog/",
+			wantStatus: http.StatusOK,
+			contains:   []string{"<h1>Blog</h1>", reloadScript},
+		},
+		{
+			name:       "html without body",
+			path:       "/fragment.html",
+			wantStatus: http.StatusOK,
+			contains:   []string{"<p>No body tag</p>" + reloadScript},
+		},
+		{
+			name:       "static files are untouched",
+			path:       "/css/style.css",
+			wantStatus: http.StatusOK,
+			contains:   []string{"body { color: red; }"},
+			excludes:   []string{reloadScript},
+		},
+		{
+			name:       "missing page",
+			path:       "/missing/",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, substr := range tt.contains {
+				if !strings.Contains(string(body), substr) {
+					t.Errorf("Response does not contain %q", substr)
+				}
+			}
+			for _, substr := range tt.excludes {
+				if strings.Contains(string(body), substr) {
+					t.Errorf("Response should not contain %q", substr)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchReloadsBrowsers(t *testing.T) {
+	dir := setupTestOutput(t)
+	srcDir := t.TempDir()
+	src := filepath.Join(srcDir, "post.md")
+	if err := os.WriteFile(src, []byte("# Post"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var builds atomic.Int32
+	s := New(dir, func() error {
+		builds.Add(1)
+		return nil
+	}, []string{srcDir})
+	s.interval = 10 * time.Millisecond
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, err := http.Get(ts.URL + reloadPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	go s.Watch(ctx)
+
+	// Give the watcher time to take its first snapshot
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(src, []byte("# Post, edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		events <- line
+	}()
+
+	select {
+	case line := <-events:
+		if line != "data: reload\n" {
+			t.Errorf("event = %q, want reload", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reload event after change")
+	}
+
+	if builds.Load() != 1 {
+		t.Errorf("builds = %d, want 1", builds.Load())
+	}
+}
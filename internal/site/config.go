@@ -0,0 +1,361 @@
+package site
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the contents of site.yaml (or site.toml) after the selected environment has
+// been applied on top of the top-level settings.
+type Config struct {
+	Title       string                 `yaml:"title"`
+	Description string                 `yaml:"description"` // default meta description
+	Author      string                 `yaml:"author"`
+	BaseURL     string                 `yaml:"baseURL"`
+	ContentDir  string                 `yaml:"contentDir"`
+	OutputDir   string                 `yaml:"outputDir"`
+	StaticDir   string                 `yaml:"staticDir"`
+	TemplateDir string                 `yaml:"templateDir"`
+	CacheDir    string                 `yaml:"cacheDir"`
+	Params      map[string]interface{} `yaml:"params"`
+	Menu        []MenuItem             `yaml:"menu"`
+	Social      []SocialLink           `yaml:"social"`
+	Feeds       FeedConfig             `yaml:"feeds"`
+	Sitemap     SitemapConfig          `yaml:"sitemap"`
+	Robots      RobotsConfig           `yaml:"robots"`
+	Taxonomies  []TaxonomyConfig       `yaml:"taxonomies"`
+	Home        HomeConfig             `yaml:"home"`
+	Pagination  PaginationConfig       `yaml:"pagination"`
+	Markup      MarkupConfig           `yaml:"markup"`
+	Cards       CardsConfig            `yaml:"cards"`
+	Assets      AssetsConfig           `yaml:"assets"`
+	Images      ImagesConfig           `yaml:"images"`
+
+	// Schemas constrain the front matter of pages, keyed by section
+	Schemas map[string]SchemaConfig `yaml:"schemas"`
+
+	// SummaryLength caps generated summaries, in characters
+	SummaryLength int `yaml:"summaryLength"`
+
+	// Environment is the name of the overlay that was applied
+	Environment string `yaml:"-"`
+}
+
+type MenuItem struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+}
+
+type SocialLink struct {
+	Name  string `yaml:"name"`
+	URL   string `yaml:"url"`
+	Label string `yaml:"label"` // text shown instead of the URL
+	Icon  string `yaml:"icon"`
+}
+
+type FeedConfig struct {
+	Limit       int      `yaml:"limit"`       // entries per feed, 0 for all
+	FullContent bool     `yaml:"fullContent"` // publish the whole body instead of the summary
+	Sections    []string `yaml:"sections"`    // collections that get their own feed
+	Tags        bool     `yaml:"tags"`        // generate a feed per tag
+}
+
+type HomeConfig struct {
+	Section  string `yaml:"section"` // section whose newest pages the home page lists
+	Recent   int    `yaml:"recent"`
+	Paginate bool   `yaml:"paginate"` // page through the whole section, Recent pages at a time
+}
+
+type MarkupConfig struct {
+	HeadingAnchors bool             `yaml:"headingAnchors"` // add a "#" link to every heading
+	TOC            TOCConfig        `yaml:"toc"`
+	Extensions     ExtensionsConfig `yaml:"extensions"`
+	Highlight      HighlightConfig  `yaml:"highlight"`
+	Unsafe         bool             `yaml:"unsafe"` // keep raw HTML instead of omitting it
+
+	// BrokenRefs is "error" to fail the build on links to missing content
+	// files or headings, or "warning" to only log them
+	BrokenRefs string `yaml:"brokenRefs"`
+}
+
+// HighlightConfig controls syntax highlighting of fenced code blocks.
+// Styles are chroma style names; the stylesheet uses the light one with
+// the light theme and the dark one otherwise.
+type HighlightConfig struct {
+	Enabled     bool   `yaml:"enabled"`
+	LineNumbers bool   `yaml:"lineNumbers"` // number the lines of blocks that don't set linenos
+	LightStyle  string `yaml:"lightStyle"`
+	DarkStyle   string `yaml:"darkStyle"`
+}
+
+// ExtensionsConfig turns goldmark extensions on and off
+type ExtensionsConfig struct {
+	Table          bool `yaml:"table"`
+	Strikethrough  bool `yaml:"strikethrough"`
+	TaskList       bool `yaml:"taskList"`
+	Linkify        bool `yaml:"linkify"` // turn bare URLs into links
+	Footnote       bool `yaml:"footnote"`
+	DefinitionList bool `yaml:"definitionList"`
+	Typographer    bool `yaml:"typographer"` // smart quotes, dashes and ellipses
+}
+
+// CardsConfig controls the preview images generated for dated pages
+// without an image. Colors are #rrggbb.
+type CardsConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Background string `yaml:"background"`
+	Foreground string `yaml:"foreground"` // title
+	Accent     string `yaml:"accent"`     // site name, tags and the bar on the left
+	Muted      string `yaml:"muted"`      // date
+	Font       string `yaml:"font"`       // TTF or OTF file, the Go fonts by default
+	Logo       string `yaml:"logo"`       // PNG, JPEG or GIF drawn in the top right corner
+}
+
+// AssetsConfig controls the processing of the CSS and JS files copied
+// from static/ or generated. Templates link to the results with the asset
+// function.
+type AssetsConfig struct {
+	Minify      bool `yaml:"minify"`
+	Fingerprint bool `yaml:"fingerprint"` // add a content hash to file names
+
+	// Bundles concatenates files, keyed by the name of the result
+	Bundles map[string][]string `yaml:"bundles"`
+}
+
+// ImagesConfig controls the resized copies made of the JPEG, PNG and GIF
+// images in content, which browsers pick from by screen size
+type ImagesConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Widths  []int  `yaml:"widths"`  // in pixels; images are never enlarged
+	Sizes   string `yaml:"sizes"`   // the sizes attribute: how wide images are shown
+	Quality int    `yaml:"quality"` // JPEG quality, 1 to 100
+}
+
+type TOCConfig struct {
+	MinDepth int `yaml:"minDepth"` // heading levels listed in tables of contents
+	MaxDepth int `yaml:"maxDepth"`
+}
+
+type SchemaConfig struct {
+	Required []string            `yaml:"required"` // fields every page must set
+	Allowed  map[string][]string `yaml:"allowed"`  // the only values a field may take
+}
+
+type PaginationConfig struct {
+	PageSize int    `yaml:"pageSize"` // pages per list page, 0 disables pagination
+	Path     string `yaml:"path"`     // URL segment before the page number, as in /blog/page/2/
+}
+
+type TaxonomyConfig struct {
+	Name  string `yaml:"name"` // front matter key and URL segment
+	Title string `yaml:"title"`
+}
+
+type SitemapConfig struct {
+	MaxURLs int `yaml:"maxURLs"` // URLs per file before splitting into a sitemap index
+}
+
+type RobotsConfig struct {
+	Disallow              []string `yaml:"disallow"`              // paths hidden from crawlers
+	DisallowNonProduction bool     `yaml:"disallowNonProduction"` // block all crawling outside production
+}
+
+// configFile is the on-disk layout: top-level settings plus named
+// environments that override them.
+type configFile struct {
+	Config       `yaml:",inline"`
+	Environments map[string]yaml.MapSlice `yaml:"environments"`
+}
+
+// DefaultConfig returns the settings used when site.yaml doesn't set them
+func DefaultConfig() *Config {
+	return &Config{
+		BaseURL:     "http://localhost:8080",
+		ContentDir:  "content",
+		OutputDir:   "public",
+		StaticDir:   "static",
+		TemplateDir: "templates",
+		CacheDir:    ".cache",
+		Params:      make(map[string]interface{}),
+		Feeds: FeedConfig{
+			Limit:    20,
+			Sections: []string{"blog"},
+			Tags:     true,
+		},
+		Sitemap: SitemapConfig{
+			MaxURLs: 50000,
+		},
+		Robots: RobotsConfig{
+			DisallowNonProduction: true,
+		},
+		Taxonomies: []TaxonomyConfig{
+			{Name: "tags", Title: "Tags"},
+			{Name: "categories", Title: "Categories"},
+		},
+		Home: HomeConfig{
+			Section: "blog",
+			Recent:  5,
+		},
+		Pagination: PaginationConfig{
+			PageSize: 10,
+			Path:     "page",
+		},
+		Markup: MarkupConfig{
+			HeadingAnchors: true,
+			TOC: TOCConfig{
+				MinDepth: 2,
+				MaxDepth: 3,
+			},
+			Extensions: ExtensionsConfig{
+				Table:          true,
+				Strikethrough:  true,
+				TaskList:       true,
+				Linkify:        true,
+				Footnote:       true,
+				DefinitionList: true,
+				Typographer:    true,
+			},
+			Highlight: HighlightConfig{
+				Enabled:    true,
+				LightStyle: "tokyonight-day",
+				DarkStyle:  "tokyonight-night",
+			},
+			BrokenRefs: "error",
+		},
+		Images: ImagesConfig{
+			Widths:  []int{480, 800, 1200, 1600},
+			Sizes:   "(max-width: 1200px) 100vw, 1200px",
+			Quality: 80,
+		},
+		Cards: CardsConfig{
+			Background: "#1a1b26",
+			Foreground: "#c0caf5",
+			Accent:     "#2ac3de",
+			Muted:      "#565f89",
+		},
+		SummaryLength: 150,
+		Environment:   "development",
+	}
+}
+
+// LoadConfig reads the config file at path and overlays the settings of
+// env. A missing file yields the defaults.
+func LoadConfig(path, env string) (*Config, error) {
+	file := configFile{Config: *DefaultConfig()}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	isTOML := strings.EqualFold(filepath.Ext(path), ".toml")
+	if isTOML {
+		if data, err = tomlToYAML(data); err != nil {
+			return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+		}
+	}
+
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		// Lines of the converted TOML would point nowhere
+		if isTOML {
+			return nil, fmt.Errorf("failed to parse config %s: %s", path, yamlLineRef.ReplaceAllString(err.Error(), ""))
+		}
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	cfg := file.Config
+	cfg.Environment = env
+
+	overlay, ok := file.Environments[env]
+	if !ok && !standardEnvironments[env] {
+		return nil, fmt.Errorf("unknown environment %q: %s declares no such environment", env, path)
+	}
+
+	// Fields missing from the overlay keep their top-level values
+	if ok {
+		raw, err := yaml.Marshal(overlay)
+		if err != nil {
+			return nil, err
+		}
+
+		// Params are merged key by key instead of being replaced
+		params := cfg.Params
+		cfg.Params = nil
+		if err := yaml.UnmarshalStrict(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s environment in %s: %w", env, path, err)
+		}
+
+		merged := make(map[string]interface{}, len(params)+len(cfg.Params))
+		for k, v := range params {
+			merged[k] = v
+		}
+		for k, v := range cfg.Params {
+			merged[k] = v
+		}
+		cfg.Params = merged
+	}
+
+	cfg.BaseURL = normalizeBaseURL(cfg.BaseURL)
+	return &cfg, nil
+}
+
+// standardEnvironments can be selected without an overlay in the config
+var standardEnvironments = map[string]bool{
+	"development": true,
+	"production":  true,
+	"preview":     true,
+}
+
+// yamlLineRef matches the line numbers in YAML decoding errors
+var yamlLineRef = regexp.MustCompile(`line \d+: `)
+
+// tomlToYAML converts a TOML config to YAML so both formats go through the
+// same strict decoding
+func tomlToYAML(data []byte) ([]byte, error) {
+	var settings map[string]interface{}
+	if _, err := toml.Decode(string(data), &settings); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(settings)
+}
+
+// ApplyEnv lets SITE_* environment variables override the config
+func (c *Config) ApplyEnv() {
+	overrides := []struct {
+		key   string
+		field *string
+	}{
+		{"SITE_INPUT_DIR", &c.ContentDir},
+		{"SITE_OUTPUT_DIR", &c.OutputDir},
+		{"SITE_STATIC_DIR", &c.StaticDir},
+		{"SITE_TEMPLATE_DIR", &c.TemplateDir},
+		{"SITE_CACHE_DIR", &c.CacheDir},
+		{"SITE_NAME", &c.Title},
+		{"SITE_BASE_URL", &c.BaseURL},
+	}
+
+	for _, o := range overrides {
+		if value, exists := os.LookupEnv(o.key); exists {
+			*o.field = value
+		}
+	}
+
+	c.BaseURL = normalizeBaseURL(c.BaseURL)
+}
+
+// normalizeBaseURL ensures a protocol and strips the trailing slash
+func normalizeBaseURL(baseURL string) string {
+	if baseURL == "" {
+		return ""
+	}
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		baseURL = "https://" + baseURL
+	}
+	return strings.TrimSuffix(baseURL, "/")
+}
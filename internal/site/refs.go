@@ -0,0 +1,43 @@
+package site
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Ref resolves a reference to a content file, as in "blog/post.md" or
+// "../about.md#why", to the permalink of its page or section. Targets
+// starting with ./ or ../ are relative to the directory of the source
+// file at from, others to the content directory. A #fragment must name a
+// heading of the target.
+func (s *Site) Ref(from, target string) (string, error) {
+	file, fragment, _ := strings.Cut(target, "#")
+
+	var rel string
+	if strings.HasPrefix(file, "./") || strings.HasPrefix(file, "../") {
+		dir, err := filepath.Rel(s.InputDir, filepath.Dir(from))
+		if err != nil {
+			return "", fmt.Errorf("failed to get relative path: %w", err)
+		}
+		rel = path.Join(filepath.ToSlash(dir), file)
+	} else {
+		rel = path.Clean(strings.TrimPrefix(file, "/"))
+	}
+
+	page, ok := s.Sources[rel]
+	if !ok {
+		return "", fmt.Errorf("no published content file %s", rel)
+	}
+	if fragment == "" {
+		return page.Permalink, nil
+	}
+
+	for _, anchor := range page.Anchors {
+		if anchor == fragment {
+			return page.Permalink + "#" + fragment, nil
+		}
+	}
+	return "", fmt.Errorf("no heading #%s in %s", fragment, rel)
+}
@@ -0,0 +1,196 @@
+package site
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// descriptionLength caps meta descriptions, in characters; search engines
+// cut longer ones
+const descriptionLength = 160
+
+// SEO is the metadata of a page for search engines and link previews,
+// written out by the "seo" template. URLs are absolute.
+type SEO struct {
+	Title       string
+	Description string
+	Canonical   string
+	Type        string // og:type: "article" for dated pages, "website" otherwise
+	Image       string // cover image, empty when the page has none
+	Published   time.Time
+	Modified    time.Time
+	Tags        []string
+	Author      string
+	SiteName    string
+	TwitterCard string // "summary_large_image" with an image, "summary" without
+
+	// JSONLD holds schema.org objects, each written as an
+	// application/ld+json script
+	JSONLD []map[string]interface{}
+}
+
+// SEO computes the page's metadata from its front matter and the site
+func (p Page) SEO() *SEO {
+	baseURL := p.BaseURL
+	siteName := p.SiteName
+	author := p.Author
+	var social []SocialLink
+	var siteDescription string
+	if p.Site != nil {
+		baseURL, siteName, social = p.Site.BaseURL, p.Site.SiteName, p.Site.Social
+		if author == "" {
+			author = p.Site.Author
+		}
+		if p.Site.Config != nil {
+			siteDescription = p.Site.Config.Description
+		}
+	}
+
+	seo := &SEO{
+		Title:     p.Title,
+		Canonical: baseURL + p.Permalink,
+		Type:      "website",
+		Tags:      p.Tags,
+		Author:    author,
+		SiteName:  siteName,
+	}
+	if seo.Title == "" {
+		seo.Title = siteName
+	}
+	// Later list pages are their own canonical URLs
+	if p.Paginator != nil && p.Paginator.URL != "" {
+		seo.Canonical = baseURL + p.Paginator.URL
+	}
+
+	switch {
+	case p.Description != "":
+		seo.Description = p.Description
+	case p.Summary != "":
+		seo.Description = p.Summary
+	default:
+		seo.Description = siteDescription
+	}
+	seo.Description = truncate(strings.Join(strings.Fields(seo.Description), " "), descriptionLength)
+
+	if p.Image != "" {
+		seo.Image = absoluteURL(baseURL, p.Permalink, p.Image)
+		seo.TwitterCard = "summary_large_image"
+	} else {
+		seo.TwitterCard = "summary"
+	}
+
+	// Dated content pages are articles; lists carry their pages instead
+	isArticle := !p.Date.IsZero() && p.Pages == nil
+	if isArticle {
+		seo.Type = "article"
+		seo.Published = p.Date
+		seo.Modified = p.Date
+		if !p.Lastmod.IsZero() {
+			seo.Modified = p.Lastmod
+		}
+	}
+
+	person := map[string]interface{}{
+		"@type": "Person",
+		"name":  author,
+		"url":   baseURL + "/",
+	}
+
+	if p.Permalink == "/" {
+		seo.JSONLD = append(seo.JSONLD, map[string]interface{}{
+			"@context":    "https://schema.org",
+			"@type":       "WebSite",
+			"name":        siteName,
+			"url":         baseURL + "/",
+			"description": seo.Description,
+		})
+		if author != "" {
+			owner := map[string]interface{}{"@context": "https://schema.org"}
+			for key, value := range person {
+				owner[key] = value
+			}
+			var sameAs []string
+			for _, link := range social {
+				if strings.HasPrefix(link.URL, "http://") || strings.HasPrefix(link.URL, "https://") {
+					sameAs = append(sameAs, link.URL)
+				}
+			}
+			if len(sameAs) > 0 {
+				owner["sameAs"] = sameAs
+			}
+			seo.JSONLD = append(seo.JSONLD, owner)
+		}
+	}
+
+	if isArticle {
+		posting := map[string]interface{}{
+			"@context":         "https://schema.org",
+			"@type":            "BlogPosting",
+			"headline":         seo.Title,
+			"url":              seo.Canonical,
+			"mainEntityOfPage": seo.Canonical,
+			"datePublished":    seo.Published.Format(time.RFC3339),
+			"dateModified":     seo.Modified.Format(time.RFC3339),
+		}
+		if seo.Description != "" {
+			posting["description"] = seo.Description
+		}
+		if seo.Image != "" {
+			posting["image"] = seo.Image
+		}
+		if len(seo.Tags) > 0 {
+			posting["keywords"] = strings.Join(seo.Tags, ", ")
+		}
+		if author != "" {
+			posting["author"] = person
+		}
+		seo.JSONLD = append(seo.JSONLD, posting)
+	}
+
+	if len(p.Breadcrumbs) > 1 {
+		items := make([]map[string]interface{}, len(p.Breadcrumbs))
+		for i, crumb := range p.Breadcrumbs {
+			items[i] = map[string]interface{}{
+				"@type":    "ListItem",
+				"position": i + 1,
+				"name":     crumb.Title,
+				"item":     baseURL + crumb.Permalink,
+			}
+		}
+		seo.JSONLD = append(seo.JSONLD, map[string]interface{}{
+			"@context":        "https://schema.org",
+			"@type":           "BreadcrumbList",
+			"itemListElement": items,
+		})
+	}
+
+	return seo
+}
+
+// absoluteURL resolves a URL from front matter: absolute ones are kept,
+// root-relative ones get the base URL and others are relative to the page
+func absoluteURL(baseURL, permalink, ref string) string {
+	switch {
+	case strings.HasPrefix(ref, "http://"), strings.HasPrefix(ref, "https://"):
+		return ref
+	case strings.HasPrefix(ref, "/"):
+		return baseURL + ref
+	default:
+		return baseURL + permalink + strings.TrimPrefix(ref, "./")
+	}
+}
+
+// truncate shortens s to at most n characters, at a word boundary when
+// there is one, marking the cut with an ellipsis
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)[:n-1]
+	cut := string(runes)
+	if i := strings.LastIndexByte(cut, ' '); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
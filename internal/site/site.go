@@ -8,22 +8,59 @@ type Page struct {
 	Title        string
 	Body         string
 	RawBody      string
+	BodyLine     int // line of the source file RawBody starts on
 	TemplateName string
 	Date         time.Time
+	Lastmod      time.Time
+	PublishDate  time.Time // not published before this time
+	ExpiryDate   time.Time // not published from this time on
 	Draft        bool
+	NoSitemap    bool // front matter "sitemap: false"
+	Weight       int
+	Paginate     int      // page size of a section index, 0 for one page
+	Slug         string   // replaces the file name in the permalink
+	Aliases      []string // permalinks that redirect to this page
+	Author       string
+	Image        string
 	Tags         []string
 	Categories   []string
-	Summary      string
+	Summary      string // plain text
+	SummaryHTML  string
+	Truncated    bool // the summary doesn't cover the whole page
 	Description  string
 	Metadata     map[string]interface{}
 
+	// TableOfContents holds the page's headings, nested by level, and
+	// TableOfContentsHTML the same as nested lists
+	TableOfContents     []*TOCEntry
+	TableOfContentsHTML string
+
+	// Anchors are the ids of all the page's headings, for links to them
+	Anchors []string
+
+	// Shortcodes are the shortcode calls of the page. Until the builder
+	// runs them, Body and SummaryHTML hold placeholders in their place.
+	Shortcodes []Shortcode
+
+	// Terms links the page to its term pages, keyed by taxonomy name
+	Terms map[string][]TermLink
+
+	// Section is the content directory the page lives in, "" for the root
+	Section     string
+	Breadcrumbs []Breadcrumb
+
 	// For lists
-	Pages []*Page
+	Pages       []*Page
+	Subsections []*Section `json:"-"` // set on section list pages
+	Taxonomy    *Taxonomy  `json:"-"` // set on taxonomy index pages
+	Term        *Term      `json:"-"` // set on term list pages
+	Paginator   *Pager     `json:"-"` // set on paginated lists, whose Pages are the current page's
 
 	// Site context
 	SiteName string
 	BaseURL  string
 	Language string
+	Site     *Site `json:"-"`
 }
 
 type Site struct {
@@ -31,13 +68,199 @@ type Site struct {
 	OutputDir   string
 	StaticDir   string
 	TemplateDir string
+	CacheDir    string // build cache; empty disables caching between runs
 	SiteName    string
 	BaseURL     string
 	Pages       []*Page
 	Collections map[string][]*Page // "posts", "pages", etc.
+
+	// Settings from site.yaml, exposed to templates through Page.Site
+	Author      string
+	Environment string
+	Params      map[string]interface{}
+	Menu        []MenuItem
+	Social      []SocialLink
+	Config      *Config
+
+	// Sections are keyed by content directory, "" being the root
+	Sections map[string]*Section
+
+	// Taxonomies are built from all published pages, keyed by name
+	Taxonomies map[string]*Taxonomy
+
+	// Feeds are advertised to browsers and feed readers by base.html
+	Feeds []FeedLink
+
+	// Assets are the processed CSS and JS files, keyed by the name of
+	// their source
+	Assets map[string]*Asset
+
+	// Sources holds every published page and section index by the path of
+	// its file relative to the content directory, for Ref
+	Sources map[string]*Page
+}
+
+// Section is a content directory. Its optional _index.md sets the title,
+// description and list body, and how the section's pages are rendered.
+type Section struct {
+	Name         string // path relative to the content directory
+	Title        string
+	Description  string
+	Body         string // rendered content of _index.md
+	Permalink    string
+	Template     string // default template of the section's pages
+	ListTemplate string
+	Sort         string // "date", "weight" or "title"
+	PageSize     int    // pages per list page, 0 for a single page
+	Parent       *Section
+	Sections     []*Section // child sections, sorted by name
+	Pages        []*Page    // pages directly in the section, in Sort order
+}
+
+// Shortcode is a call of a template in templates/shortcodes/ from
+// markdown, as in {{< figure src="a.png" >}}. Paired calls wrap markdown:
+// {{< note >}}text{{< /note >}}.
+type Shortcode struct {
+	Name      string
+	Args      []string          // positional arguments
+	Params    map[string]string // named arguments
+	Paired    bool
+	InnerHTML string // rendered markdown between paired tags
+	Line      int    // line of the opening tag in the source file
+
+	// Placeholder marks where the output goes in Body and InnerHTML
+	Placeholder string
+}
+
+// HookContext is what a render hook template in templates/_hooks/
+// executes with. Fields that don't apply to an element are empty.
+type HookContext struct {
+	Path        string // source file being rendered
+	Destination string // URL of a link or image
+	Title       string // title of a link or image
+	External    bool   // the link or image points to another site
+	Text        string // rendered HTML of the link text, heading or code block
+	PlainText   string // text without markup, such as an image's alt text
+	Level       int    // heading level
+	Anchor      string // heading id
+	Lang        string // code block language
+	Code        string // code block source
+	Width       int    // intrinsic size of a local image in pixels
+	Height      int
+	Srcset      string // resized copies of a local image, as in "/a.1f2e3d4c-480w.jpg 480w, ..."
+	Sizes       string // how wide the image is shown, for picking from Srcset
+	Site        *Site
+}
+
+// Asset is a CSS or JS file as processed for the output
+type Asset struct {
+	Name      string // path of the source relative to the output directory
+	URL       string // fingerprinted when enabled
+	Integrity string // Subresource Integrity hash of the contents
+}
+
+type TOCEntry struct {
+	Level    int
+	Text     string
+	ID       string
+	Children []*TOCEntry
+}
+
+type Breadcrumb struct {
+	Title     string
+	Permalink string
+}
+
+// Pager is one page of a paginated list. URLs are permalinks; Prev and
+// Next are empty on the first and last page.
+type Pager struct {
+	Number     int
+	TotalPages int
+	TotalItems int
+	Pages      []*Page
+	URL        string
+	First      string
+	Prev       string
+	Next       string
+	Last       string
+	Numbers    []PagerLink
+}
+
+type PagerLink struct {
+	Number  int
+	URL     string
+	Current bool
+}
+
+func (p *Pager) HasPrev() bool { return p.Prev != "" }
+func (p *Pager) HasNext() bool { return p.Next != "" }
+
+// Taxonomy groups pages by the values of one front matter field, such as
+// tags or categories.
+type Taxonomy struct {
+	Name      string // front matter key, also the URL segment
+	Title     string
+	Permalink string
+	Terms     []*Term // sorted by name
+}
+
+type Term struct {
+	Name      string
+	Slug      string
+	Permalink string
+	Pages     []*Page // newest first
+}
+
+// Count returns the number of pages using the term
+func (t *Term) Count() int {
+	return len(t.Pages)
+}
+
+type TermLink struct {
+	Name      string
+	Permalink string
+}
+
+type FeedLink struct {
+	Title string
+	Type  string // MIME type
+	URL   string
+}
+
+// New creates a site from a loaded config
+func New(cfg *Config) *Site {
+	s := NewWithConfig(
+		cfg.ContentDir,
+		cfg.OutputDir,
+		cfg.StaticDir,
+		cfg.TemplateDir,
+		cfg.Title,
+		cfg.BaseURL,
+	)
+	s.CacheDir = cfg.CacheDir
+	s.Author = cfg.Author
+	s.Environment = cfg.Environment
+	s.Params = cfg.Params
+	s.Menu = cfg.Menu
+	s.Social = cfg.Social
+	s.Config = cfg
+	return s
+}
+
+// IsProduction reports whether the site is built for the production environment
+func (s *Site) IsProduction() bool {
+	return s.Environment == "production"
 }
 
 func NewWithConfig(input, output, static, templateDir, siteName, baseURL string) *Site {
+	cfg := DefaultConfig()
+	cfg.ContentDir = input
+	cfg.OutputDir = output
+	cfg.StaticDir = static
+	cfg.TemplateDir = templateDir
+	cfg.Title = siteName
+	cfg.BaseURL = baseURL
+
 	return &Site{
 		InputDir:    input,
 		OutputDir:   output,
@@ -46,5 +269,8 @@ func NewWithConfig(input, output, static, templateDir, siteName, baseURL string)
 		SiteName:    siteName,
 		BaseURL:     baseURL,
 		Collections: make(map[string][]*Page),
+		Environment: cfg.Environment,
+		Params:      cfg.Params,
+		Config:      cfg,
 	}
 }
@@ -1,8 +1,13 @@
 package site
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 )
 
 func TestNewWithConfig(t *testing.T) {
@@ -162,3 +167,341 @@ func TestSiteCollections(t *testing.T) {
 		}
 	})
 }
+
+func TestSiteRef(t *testing.T) {
+	s := NewWithConfig("content", "public", "static", "templates", "Test Site", "")
+	s.Sources = map[string]*Page{
+		"about.md":       {Permalink: "/about/", Anchors: []string{"why"}},
+		"blog/post.md":   {Permalink: "/blog/my-post/"},
+		"blog/_index.md": {Permalink: "/blog/"},
+	}
+	from := filepath.Join("content", "blog", "other.md")
+
+	tests := []struct {
+		target  string
+		want    string
+		wantErr string
+	}{
+		{"about.md", "/about/", ""},
+		{"/about.md#why", "/about/#why", ""},
+		{"../about.md#why", "/about/#why", ""},
+		{"./post.md", "/blog/my-post/", ""},
+		{"blog/post.md", "/blog/my-post/", ""},
+		{"blog/_index.md", "/blog/", ""},
+		{"post.md", "", "no published content file post.md"},
+		{"../about.md#how", "", "no heading #how in about.md"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, err := s.Ref(from, tt.target)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("Ref() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Ref() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageSEO(t *testing.T) {
+	s := NewWithConfig("content", "public", "static", "templates", "Test Site", "https://example.com")
+	s.Author = "Jane Doe"
+	s.Config.Description = "A site about tests"
+	s.Social = []SocialLink{{Name: "GitHub", URL: "https://github.com/jane"}, {Name: "Email", URL: "mailto:jane@example.com"}}
+
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	post := Page{
+		Title:     "A Post",
+		Permalink: "/blog/a-post/",
+		Summary:   "The   summary\nof the post.",
+		Date:      date,
+		Lastmod:   date.AddDate(0, 1, 0),
+		Tags:      []string{"go", "web"},
+		Image:     "cover.png",
+		Breadcrumbs: []Breadcrumb{
+			{Title: "Home", Permalink: "/"},
+			{Title: "Blog", Permalink: "/blog/"},
+			{Title: "A Post", Permalink: "/blog/a-post/"},
+		},
+		Site: s,
+	}
+
+	t.Run("article", func(t *testing.T) {
+		seo := post.SEO()
+		if seo.Canonical != "https://example.com/blog/a-post/" || seo.Type != "article" {
+			t.Errorf("Canonical, Type = %q, %q", seo.Canonical, seo.Type)
+		}
+		if seo.Description != "The summary of the post." {
+			t.Errorf("Description = %q", seo.Description)
+		}
+		if seo.Image != "https://example.com/blog/a-post/cover.png" || seo.TwitterCard != "summary_large_image" {
+			t.Errorf("Image, TwitterCard = %q, %q", seo.Image, seo.TwitterCard)
+		}
+		if !seo.Published.Equal(date) || !seo.Modified.Equal(post.Lastmod) || seo.Author != "Jane Doe" {
+			t.Errorf("Published, Modified, Author = %v, %v, %q", seo.Published, seo.Modified, seo.Author)
+		}
+
+		var types []interface{}
+		for _, block := range seo.JSONLD {
+			types = append(types, block["@type"])
+		}
+		if want := []interface{}{"BlogPosting", "BreadcrumbList"}; !reflect.DeepEqual(types, want) {
+			t.Fatalf("JSON-LD types = %v, want %v", types, want)
+		}
+		posting := seo.JSONLD[0]
+		if posting["datePublished"] != "2024-03-01T00:00:00Z" || posting["keywords"] != "go, web" {
+			t.Errorf("BlogPosting = %v", posting)
+		}
+		items := seo.JSONLD[1]["itemListElement"].([]map[string]interface{})
+		if len(items) != 3 || items[2]["item"] != "https://example.com/blog/a-post/" || items[2]["position"] != 3 {
+			t.Errorf("BreadcrumbList items = %v", items)
+		}
+	})
+
+	t.Run("home", func(t *testing.T) {
+		home := Page{Title: "Home", Permalink: "/", Site: s}
+		seo := home.SEO()
+		if seo.Type != "website" || seo.Description != "A site about tests" || seo.TwitterCard != "summary" {
+			t.Errorf("Type, Description, TwitterCard = %q, %q, %q", seo.Type, seo.Description, seo.TwitterCard)
+		}
+		if len(seo.JSONLD) != 2 || seo.JSONLD[0]["@type"] != "WebSite" || seo.JSONLD[1]["@type"] != "Person" {
+			t.Fatalf("JSON-LD = %v, want WebSite and Person", seo.JSONLD)
+		}
+		if sameAs := seo.JSONLD[1]["sameAs"]; !reflect.DeepEqual(sameAs, []string{"https://github.com/jane"}) {
+			t.Errorf("sameAs = %v", sameAs)
+		}
+	})
+
+	t.Run("paginated list", func(t *testing.T) {
+		list := Page{Title: "Blog", Permalink: "/blog/", Date: date, Pages: []*Page{&post}, Paginator: &Pager{URL: "/blog/page/2/"}, Site: s}
+		seo := list.SEO()
+		if seo.Type != "website" || seo.Canonical != "https://example.com/blog/page/2/" {
+			t.Errorf("Type, Canonical = %q, %q", seo.Type, seo.Canonical)
+		}
+	})
+
+	t.Run("long description", func(t *testing.T) {
+		page := Page{Description: strings.Repeat("word ", 50), Site: s}
+		got := page.SEO().Description
+		if n := utf8.RuneCountInString(got); n > descriptionLength || !strings.HasSuffix(got, "word…") {
+			t.Errorf("Description = %q (%d characters)", got, n)
+		}
+	})
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "site.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+title: Test Site
+author: Jane Doe
+baseURL: example.com/
+params:
+  tagline: Hello
+  nested:
+    key: value
+menu:
+  - name: Home
+    url: /
+social:
+  - name: GitHub
+    url: https://github.com/example
+    icon: github
+environments:
+  production:
+    baseURL: https://prod.example.com
+    params:
+      tagline: Production
+  preview:
+    title: Test Site (Preview)
+`)
+
+	tests := []struct {
+		name        string
+		env         string
+		wantTitle   string
+		wantBaseURL string
+		wantTagline string
+	}{
+		{
+			name:        "environment without overlay",
+			env:         "development",
+			wantTitle:   "Test Site",
+			wantBaseURL: "https://example.com",
+			wantTagline: "Hello",
+		},
+		{
+			name:        "production overlay",
+			env:         "production",
+			wantTitle:   "Test Site",
+			wantBaseURL: "https://prod.example.com",
+			wantTagline: "Production",
+		},
+		{
+			name:        "preview overlay",
+			env:         "preview",
+			wantTitle:   "Test Site (Preview)",
+			wantBaseURL: "https://example.com",
+			wantTagline: "Hello",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(path, tt.env)
+			if err != nil {
+				t.Fatalf("LoadConfig() error = %v", err)
+			}
+
+			if cfg.Title != tt.wantTitle {
+				t.Errorf("Title = %v, want %v", cfg.Title, tt.wantTitle)
+			}
+			if cfg.BaseURL != tt.wantBaseURL {
+				t.Errorf("BaseURL = %v, want %v", cfg.BaseURL, tt.wantBaseURL)
+			}
+			if cfg.Params["tagline"] != tt.wantTagline {
+				t.Errorf("Params[tagline] = %v, want %v", cfg.Params["tagline"], tt.wantTagline)
+			}
+			if cfg.Params["nested"] == nil {
+				t.Error("Params not set in the overlay should be kept")
+			}
+			if cfg.Environment != tt.env {
+				t.Errorf("Environment = %v, want %v", cfg.Environment, tt.env)
+			}
+			if len(cfg.Menu) != 1 || len(cfg.Social) != 1 {
+				t.Errorf("Menu/Social = %d/%d entries, want 1/1", len(cfg.Menu), len(cfg.Social))
+			}
+			if cfg.ContentDir != "content" {
+				t.Errorf("ContentDir = %v, want default 'content'", cfg.ContentDir)
+			}
+		})
+	}
+
+	t.Run("missing file uses defaults", func(t *testing.T) {
+		cfg, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"), "development")
+		if err != nil {
+			t.Fatalf("LoadConfig() error = %v", err)
+		}
+		if cfg.OutputDir != "public" {
+			t.Errorf("OutputDir = %v, want 'public'", cfg.OutputDir)
+		}
+	})
+
+	t.Run("unknown keys are rejected", func(t *testing.T) {
+		if _, err := LoadConfig(writeConfig(t, "titel: Typo\n"), "development"); err == nil {
+			t.Error("Expected error for unknown key")
+		}
+	})
+
+	t.Run("unknown environment", func(t *testing.T) {
+		_, err := LoadConfig(path, "prodution")
+		if err == nil || !strings.Contains(err.Error(), `"prodution"`) {
+			t.Errorf("LoadConfig() error = %v, want unknown environment", err)
+		}
+	})
+
+	t.Run("declared environment", func(t *testing.T) {
+		cfg, err := LoadConfig(writeConfig(t, "environments:\n  staging:\n    title: Staging\n"), "staging")
+		if err != nil {
+			t.Fatalf("LoadConfig() error = %v", err)
+		}
+		if cfg.Title != "Staging" {
+			t.Errorf("Title = %v, want Staging", cfg.Title)
+		}
+	})
+
+	t.Run("toml", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "site.toml")
+		content := `title = "Test Site"
+baseURL = "example.com"
+
+[params]
+tagline = "Hello"
+
+[[menu]]
+name = "Home"
+url = "/"
+
+[pagination]
+pageSize = 5
+
+[environments.production]
+baseURL = "https://prod.example.com"
+`
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := LoadConfig(path, "production")
+		if err != nil {
+			t.Fatalf("LoadConfig() error = %v", err)
+		}
+		if cfg.Title != "Test Site" || cfg.BaseURL != "https://prod.example.com" || cfg.Params["tagline"] != "Hello" {
+			t.Errorf("LoadConfig() = %q %q %v", cfg.Title, cfg.BaseURL, cfg.Params)
+		}
+		if len(cfg.Menu) != 1 || cfg.Pagination.PageSize != 5 {
+			t.Errorf("Menu = %v, PageSize = %d", cfg.Menu, cfg.Pagination.PageSize)
+		}
+
+		if err := os.WriteFile(path, []byte("titel = \"Typo\"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err = LoadConfig(path, "development")
+		if err == nil || !strings.Contains(err.Error(), "titel") || strings.Contains(err.Error(), "line") {
+			t.Errorf("LoadConfig() error = %v, want unknown key without a line", err)
+		}
+	})
+}
+
+func TestConfigApplyEnv(t *testing.T) {
+	t.Setenv("SITE_NAME", "From Env")
+	t.Setenv("SITE_BASE_URL", "env.example.com")
+
+	cfg := DefaultConfig()
+	cfg.Title = "From File"
+	cfg.ApplyEnv()
+
+	if cfg.Title != "From Env" {
+		t.Errorf("Title = %v, want 'From Env'", cfg.Title)
+	}
+	if cfg.BaseURL != "https://env.example.com" {
+		t.Errorf("BaseURL = %v, want 'https://env.example.com'", cfg.BaseURL)
+	}
+
+	s := New(cfg)
+	if s.SiteName != "From Env" || s.Config != cfg {
+		t.Error("New() should copy the config into the site")
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Go", "go"},
+		{"Go & Kubernetes", "go-kubernetes"},
+		{"  CI/CD  ", "ci-cd"},
+		{"Neuquén", "neuquén"},
+		{"---", ""},
+	}
+
+	for _, tt := range tests {
+		if got := Slugify(tt.in); got != tt.want {
+			t.Errorf("Slugify(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package site
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Slugify turns a title or tag into a lowercase URL path segment:
+// "Go & Kubernetes" -> "go-kubernetes".
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+	}
+
+	return b.String()
+}
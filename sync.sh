@@ -1 +0,0 @@
-browser-sync start --server "public" --files "public/**/*.css, public/**/*.html"